@@ -4,21 +4,60 @@ import (
 	"fmt"
 	"image/color"
 	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
 )
 
-// barGraphCanvas is a custom widget that renders a bar graph
+// graphView selects which series the bar graph draws
+type graphView int
+
+const (
+	exactView   graphView = iota // chance of each outcome
+	atLeastView                  // chance of each outcome or higher
+	atMostView                   // chance of each outcome or lower
+)
+
+// graphViewNames are the labels of the views in the statistics window, in graphView order
+var graphViewNames = []string{"Exact (=)", "At least (≥)", "At most (≤)"}
+
+// graphSeries is one expression drawn on the bar graph
+type graphSeries struct {
+	expression string
+	stats      *DiceStatistics
+}
+
+// seriesColors are the bar colors of the series being compared, in order
+var seriesColors = []color.NRGBA{
+	{R: 100, G: 180, B: 255, A: 255},
+	{R: 255, G: 150, B: 70, A: 255},
+	{R: 200, G: 120, B: 255, A: 255},
+	{R: 255, G: 220, B: 90, A: 255},
+	{R: 255, G: 100, B: 140, A: 255},
+	{R: 90, G: 220, B: 210, A: 255},
+}
+
+// barGraphCanvas is a custom widget that renders a bar graph.
+// Several series are compared by drawing their bars side by side for each outcome.
 type barGraphCanvas struct {
 	widget.BaseWidget
-	stats *DiceStatistics
+	series []graphSeries
+	view   graphView
+
+	// target highlights the outcomes that succeed against it when hasTarget is set
+	target    float64
+	hasTarget bool
 }
 
-func newBarGraphCanvas(stats *DiceStatistics) *barGraphCanvas {
+func newBarGraphCanvas(series []graphSeries) *barGraphCanvas {
 	graph := &barGraphCanvas{
-		stats: stats,
+		series: series,
 	}
 	graph.ExtendBaseWidget(graph)
 	return graph
@@ -47,13 +86,24 @@ func (r *barGraphCanvasRenderer) MinSize() fyne.Size {
 func (r *barGraphCanvasRenderer) Refresh() {
 	r.objects = []fyne.CanvasObject{}
 
-	if r.graph.stats == nil || len(r.graph.stats.Results) == 0 {
+	if len(r.graph.series) == 0 || len(r.graph.series[0].stats.Results) == 0 {
 		return
 	}
 
-	stats := r.graph.stats
-	outcomes := stats.GetSortedOutcomes()
-	maxPercentage := stats.GetMaxPercentage()
+	series := r.graph.series
+	stats := series[0].stats
+	comparing := len(series) > 1
+	outcomes := combinedOutcomes(series)
+
+	// percentages[j][i] is the height of the bar of series j for outcome i
+	percentages := make([][]float64, len(series))
+	maxPercentage := 0.0
+	for j, s := range series {
+		percentages[j] = viewPercentages(s.stats, r.graph.view, outcomes)
+		for _, percentage := range percentages[j] {
+			maxPercentage = math.Max(maxPercentage, percentage)
+		}
+	}
 
 	// Round up maxPercentage to nearest 5%
 	roundedMaxPercent := math.Ceil(maxPercentage/5) * 5
@@ -61,8 +111,18 @@ func (r *barGraphCanvasRenderer) Refresh() {
 		roundedMaxPercent = 5
 	}
 
+	// Cumulative views reach 100%, so tick every 10% to keep the labels apart
+	tickStep := 5.0
+	if roundedMaxPercent > 50 {
+		tickStep = 10
+	}
+
 	// Padding
-	topPadding := float32(75)
+	topPadding := float32(85)
+	if comparing {
+		// Leave room for a legend line per series
+		topPadding = max(topPadding, float32(43+14*len(series)))
+	}
 	bottomPadding := float32(80)
 	leftPadding := float32(100)
 	rightPadding := float32(20)
@@ -96,17 +156,11 @@ func (r *barGraphCanvasRenderer) Refresh() {
 	title.Move(fyne.NewPos(leftPadding, 5))
 	r.objects = append(r.objects, title)
 
-	// Statistics info line 1
-	statsLine1 := canvas.NewText(fmt.Sprintf("Range: %d to %d  |  Total Outcomes: %d", stats.MinValue, stats.MaxValue, stats.Total), color.White)
-	statsLine1.TextSize = 11
-	statsLine1.Move(fyne.NewPos(leftPadding, 22))
-	r.objects = append(r.objects, statsLine1)
-
-	// Statistics info line 2
-	statsLine2 := canvas.NewText(fmt.Sprintf("Average: %.2f  |  Most Common: %d", stats.Average, stats.MostCommon), color.White)
-	statsLine2.TextSize = 11
-	statsLine2.Move(fyne.NewPos(leftPadding, 36))
-	r.objects = append(r.objects, statsLine2)
+	if comparing {
+		r.addLegend()
+	} else {
+		r.addStatsLines(stats)
+	}
 
 	// Y-axis label
 	yLabel := canvas.NewText("Probability (%)", color.White)
@@ -115,15 +169,23 @@ func (r *barGraphCanvasRenderer) Refresh() {
 	r.objects = append(r.objects, yLabel)
 
 	// X-axis label
-	xLabel := canvas.NewText("Result Value", color.White)
+	xLabelText := "Result Value"
+	if stats.Opposed {
+		xLabelText = "Margin of Victory"
+	} else if stats.Condition {
+		xLabelText = "Condition"
+	} else if stats.Successes {
+		xLabelText = "Successes"
+	}
+	xLabel := canvas.NewText(xLabelText, color.White)
 	xLabel.TextSize = 12
 	xLabel.Move(fyne.NewPos(leftPadding+graphWidth/2-30, topPadding+graphHeight+50))
 	r.objects = append(r.objects, xLabel)
 
 	// Y-axis tick marks and labels
-	numYTicks := int(roundedMaxPercent/5) + 1
+	numYTicks := int(math.Ceil(roundedMaxPercent / tickStep))
 	for i := 0; i <= numYTicks; i++ {
-		percent := float64(i) * 5
+		percent := float64(i) * tickStep
 
 		yPos := topPadding + graphHeight - (float32(percent/roundedMaxPercent) * graphHeight)
 
@@ -154,19 +216,28 @@ func (r *barGraphCanvasRenderer) Refresh() {
 	labelStep := calculateLabelStep(graphWidth, numBars)
 
 	for i, value := range outcomes {
-		percentage := stats.Percentages[value]
-
-		// Bar height proportional to percentage
-		barHeight := (float32(percentage) / float32(roundedMaxPercent)) * graphHeight
-
 		// X position
 		xPos := leftPadding + float32(i)*(barWidth+barSpacing*2) + barSpacing
 
-		// Draw bar
-		bar := canvas.NewRectangle(color.NRGBA{R: 100, G: 180, B: 255, A: 255})
-		bar.Move(fyne.NewPos(xPos, topPadding+graphHeight-barHeight))
-		bar.Resize(fyne.NewSize(barWidth, barHeight))
-		r.objects = append(r.objects, bar)
+		// Compared series share the outcome's slot, each taking an equal slice of it
+		seriesWidth := barWidth / float32(len(series))
+		for j := range series {
+			// Bar height proportional to percentage
+			barHeight := (float32(percentages[j][i]) / float32(roundedMaxPercent)) * graphHeight
+
+			// Draw bar. When there is a target, a single series turns green where it succeeds,
+			// while compared series keep their colors and fade where they fail.
+			barColor := seriesColors[j]
+			if r.graph.hasTarget && !comparing && r.graph.succeeds(value) {
+				barColor = color.NRGBA{R: 100, G: 210, B: 120, A: 255}
+			} else if r.graph.hasTarget && comparing && !r.graph.succeeds(value) {
+				barColor.A = 80
+			}
+			bar := canvas.NewRectangle(barColor)
+			bar.Move(fyne.NewPos(xPos+float32(j)*seriesWidth, topPadding+graphHeight-barHeight))
+			bar.Resize(fyne.NewSize(seriesWidth, barHeight))
+			r.objects = append(r.objects, bar)
+		}
 
 		// X-axis label
 		// Always show first and last label
@@ -179,7 +250,11 @@ func (r *barGraphCanvasRenderer) Refresh() {
 		showIntermediate := i%labelStep == 0 && i < numBars-labelStep
 
 		if isFirst || isLast || showIntermediate {
-			label := canvas.NewText(fmt.Sprintf("%d", value), color.White)
+			labelText := formatOutcome(value)
+			if stats.Condition {
+				labelText = strconv.FormatBool(value != 0)
+			}
+			label := canvas.NewText(labelText, color.White)
 			label.TextSize = 10
 
 			// Center label under bar
@@ -191,6 +266,87 @@ func (r *barGraphCanvasRenderer) Refresh() {
 	}
 }
 
+// succeeds reports whether an outcome succeeds against the target. Only the at most
+// view counts low rolls as successes; the others treat the target as a DC to meet.
+func (b *barGraphCanvas) succeeds(value float64) bool {
+	if b.view == atMostView {
+		return value <= b.target
+	}
+	return value >= b.target
+}
+
+// targetSummary describes the exact chance of an expression succeeding against the target
+func (b *barGraphCanvas) targetSummary(stats *DiceStatistics) string {
+	compare, chance := "≥", stats.ProbabilityAtLeast(b.target)
+	if b.view == atMostView {
+		compare, chance = "≤", stats.ProbabilityAtMost(b.target)
+	}
+
+	percent, _ := chance.Float64()
+	summary := fmt.Sprintf("P(result %s %s) = %.4g%%", compare, formatOutcome(b.target), percent*100)
+	// Show the exact fraction while it is short enough to read
+	if fraction := chance.RatString(); len(fraction) <= 20 {
+		summary += " (" + fraction + ")"
+	}
+	return summary
+}
+
+// combinedOutcomes returns every outcome of any of the series, sorted
+func combinedOutcomes(series []graphSeries) []float64 {
+	var outcomes []float64
+	for _, s := range series {
+		outcomes = append(outcomes, s.stats.GetSortedOutcomes()...)
+	}
+	slices.Sort(outcomes)
+	return slices.Compact(outcomes)
+}
+
+// viewPercentages returns the height of each outcome's bar in a view. Outcomes the
+// expression can't roll are 0 in the exact view, and in the cumulative views carry
+// the chance of the nearest outcome it can roll.
+func viewPercentages(stats *DiceStatistics, view graphView, outcomes []float64) []float64 {
+	percentages := make([]float64, len(outcomes))
+	switch view {
+	case atLeastView:
+		atLeast := stats.AtLeastPercentages()
+		carried := 0.0
+		for i := len(outcomes) - 1; i >= 0; i-- {
+			if percentage, ok := atLeast[outcomes[i]]; ok {
+				carried = percentage
+			}
+			percentages[i] = carried
+		}
+	case atMostView:
+		atMost := stats.AtMostPercentages()
+		carried := 0.0
+		for i, value := range outcomes {
+			if percentage, ok := atMost[value]; ok {
+				carried = percentage
+			}
+			percentages[i] = carried
+		}
+	default:
+		for i, value := range outcomes {
+			percentages[i] = stats.Percentages[value]
+		}
+	}
+	return percentages
+}
+
+// formatOutcome formats an outcome value, showing whole numbers without a decimal point
+func formatOutcome(value float64) string {
+	return fmt.Sprintf("%v", value)
+}
+
+// formatCount formats an outcome count, switching to scientific notation when it is too long to read
+func formatCount(count *big.Int) string {
+	text := count.String()
+	if len(text) <= 15 {
+		return text
+	}
+	return new(big.Float).SetInt(count).Text('e', 3)
+}
+
 func calculateLabelStep(graphWidth float32, numBars int) int {
 	labelWidthEstimate := float32(35) // Estimate width of a label
 	maxLabels := int(graphWidth / labelWidthEstimate)
@@ -204,6 +360,74 @@ func calculateLabelStep(graphWidth float32, numBars int) int {
 	return labelStep
 }
 
+// statsLeftPadding is where the header lines start, in line with the y-axis
+const statsLeftPadding = float32(100)
+
+// addStatsLines draws the header lines describing a single expression
+func (r *barGraphCanvasRenderer) addStatsLines(stats *DiceStatistics) {
+	// Statistics info line 1
+	statsText1 := fmt.Sprintf("Range: %s to %s  |  Total Outcomes: %s", formatOutcome(stats.MinValue), formatOutcome(stats.MaxValue), formatCount(stats.Total))
+	if stats.Opposed {
+		statsText1 += fmt.Sprintf("  |  Win: %.2f%%  |  Tie: %.2f%%  |  Lose: %.2f%%", stats.Win*100, stats.Tie*100, stats.Lose*100)
+	} else if stats.Condition {
+		statsText1 += fmt.Sprintf("  |  Chance of Success: %.2f%%", stats.Win*100)
+	}
+	statsLine1 := canvas.NewText(statsText1, color.White)
+	statsLine1.TextSize = 11
+	statsLine1.Move(fyne.NewPos(statsLeftPadding, 22))
+	r.objects = append(r.objects, statsLine1)
+
+	// Statistics info line 2
+	statsText2 := fmt.Sprintf("Average: %.2f  |  Median: %s  |  Most Common: %s  |  Std Dev: %.2f  |  Variance: %.2f",
+		stats.Average, formatOutcome(stats.Median), formatOutcome(stats.Mode), stats.StdDev, stats.Variance)
+	statsLine2 := canvas.NewText(statsText2, color.White)
+	statsLine2.TextSize = 11
+	statsLine2.Move(fyne.NewPos(statsLeftPadding, 36))
+	r.objects = append(r.objects, statsLine2)
+
+	// Statistics info line 3
+	statsText3 := fmt.Sprintf("5th-95th Percentile: %s to %s  |  IQR: %s  |  Skewness: %.3f  |  Kurtosis: %.3f",
+		formatOutcome(stats.P5), formatOutcome(stats.P95), formatOutcome(stats.IQR), stats.Skewness, stats.Kurtosis)
+	if stats.TruncatedProbability > 0 {
+		statsText3 += fmt.Sprintf("  |  Explosion tail cut off: %.4g%%", stats.TruncatedProbability*100)
+	} else if stats.Approximate {
+		statsText3 += "  |  Some values approximated"
+	}
+	statsLine3 := canvas.NewText(statsText3, color.White)
+	statsLine3.TextSize = 11
+	statsLine3.Move(fyne.NewPos(statsLeftPadding, 50))
+	r.objects = append(r.objects, statsLine3)
+
+	// Chance of succeeding against the target, if there is one
+	if r.graph.hasTarget {
+		targetLine := canvas.NewText(r.graph.targetSummary(stats), color.NRGBA{R: 120, G: 220, B: 120, A: 255})
+		targetLine.TextSize = 11
+		targetLine.Move(fyne.NewPos(statsLeftPadding, 64))
+		r.objects = append(r.objects, targetLine)
+	}
+}
+
+// addLegend draws a line per compared expression, in the color of its bars
+func (r *barGraphCanvasRenderer) addLegend() {
+	for j, s := range r.graph.series {
+		yPos := float32(22 + 14*j)
+
+		swatch := canvas.NewRectangle(seriesColors[j])
+		swatch.Move(fyne.NewPos(statsLeftPadding, yPos+2))
+		swatch.Resize(fyne.NewSize(10, 10))
+		r.objects = append(r.objects, swatch)
+
+		text := fmt.Sprintf("%s  |  Average: %.2f  |  Std Dev: %.2f", s.expression, s.stats.Average, s.stats.StdDev)
+		if r.graph.hasTarget {
+			text += "  |  " + r.graph.targetSummary(s.stats)
+		}
+		line := canvas.NewText(text, seriesColors[j])
+		line.TextSize = 11
+		line.Move(fyne.NewPos(statsLeftPadding+16, yPos))
+		r.objects = append(r.objects, line)
+	}
+}
+
 func (r *barGraphCanvasRenderer) Objects() []fyne.CanvasObject {
 	return r.objects
 }
@@ -213,18 +437,137 @@ func (r *barGraphCanvasRenderer) Destroy() {
 
 // ShowStatisticsWindow creates and shows a statistics window for the given expression
 func ShowStatisticsWindow(expression string) {
-	stats, err := CalculateDiceStatistics(expression)
+	options := DefaultStatisticsOptions
+	stats, err := CalculateDiceStatisticsWithOptions(expression, options)
 	if err != nil {
 		fmt.Printf("Error calculating statistics: %v\n", err)
 		return
 	}
 
 	// Create the bar graph
-	graph := newBarGraphCanvas(stats)
+	graph := newBarGraphCanvas([]graphSeries{{expression: expression, stats: stats}})
+
+	// View toggle between the exact and cumulative series
+	viewSelect := widget.NewRadioGroup(graphViewNames, func(selected string) {
+		graph.view = graphView(slices.Index(graphViewNames, selected))
+		graph.Refresh()
+	})
+	viewSelect.Horizontal = true
+	viewSelect.Required = true
+	viewSelect.SetSelected(graphViewNames[exactView])
+
+	// Target value, such as a DC, to highlight the rolls that succeed
+	targetEntry := widget.NewEntry()
+	targetEntry.SetPlaceHolder("Target (e.g. DC 15)")
+	targetEntry.OnChanged = func(text string) {
+		target, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		graph.target, graph.hasTarget = target, err == nil
+		graph.Refresh()
+	}
+
+	controls := container.NewBorder(nil, nil, viewSelect, nil, targetEntry)
+
+	// Comparison mode: several expressions, one per line, drawn over each other
+	compareEntry := widget.NewMultiLineEntry()
+	compareEntry.SetText(expression)
+	compareEntry.SetPlaceHolder("Expressions to compare, one per line")
+	compareEntry.SetMinRowsVisible(3)
+	compareError := widget.NewLabel("")
+	comparisonTable := container.NewVBox()
+	recalculate := func() {
+		series, err := compareSeries(compareEntry.Text, options)
+		if err != nil {
+			compareError.SetText(err.Error())
+			return
+		}
+		compareError.SetText("")
+
+		graph.series = series
+		graph.Refresh()
+		comparisonTable.RemoveAll()
+		if len(series) > 1 {
+			comparisonTable.Add(newComparisonTable(series))
+		}
+	}
+	compareButton := widget.NewButton("Compare", recalculate)
+
+	// How many extra rolls an exploding die may chain before the statistics cut it off
+	depthEntry := widget.NewEntry()
+	depthEntry.SetText(strconv.Itoa(options.ExplosionDepth))
+	depthEntry.Validator = func(text string) error {
+		_, err := parseExplosionDepth(text)
+		return err
+	}
+	depthEntry.OnSubmitted = func(text string) {
+		depth, err := parseExplosionDepth(text)
+		if err != nil {
+			return
+		}
+		options.ExplosionDepth = depth
+		recalculate()
+	}
+	depthControl := container.NewBorder(nil, nil, widget.NewLabel("Explosion depth"), nil, depthEntry)
+
+	comparison := container.NewVBox(
+		container.NewBorder(nil, nil, nil, compareButton, compareEntry),
+		compareError,
+		comparisonTable,
+	)
 
 	// Create and show the window
 	window := fyne.CurrentApp().NewWindow("Statistics: " + expression)
-	window.SetContent(graph)
-	window.Resize(fyne.NewSize(900, 550))
+	window.SetContent(container.NewBorder(container.NewVBox(controls, depthControl), comparison, nil, nil, graph))
+	window.Resize(fyne.NewSize(900, 800))
 	window.Show()
 }
+
+// compareSeries calculates the statistics of each expression on its own line
+func compareSeries(text string, options StatisticsOptions) ([]graphSeries, error) {
+	var series []graphSeries
+	for _, line := range strings.Split(text, "\n") {
+		expression := strings.TrimSpace(line)
+		if expression == "" {
+			continue
+		}
+		stats, err := CalculateDiceStatisticsWithOptions(expression, options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", expression, err)
+		}
+		series = append(series, graphSeries{expression: expression, stats: stats})
+	}
+
+	if len(series) == 0 {
+		return nil, fmt.Errorf("enter at least one expression")
+	}
+	if len(series) > len(seriesColors) {
+		return nil, fmt.Errorf("cannot compare more than %d expressions", len(seriesColors))
+	}
+	return series, nil
+}
+
+// newComparisonTable lays out the mean and standard deviation of each compared expression,
+// and the exact chance that each one rolls higher than every other
+func newComparisonTable(series []graphSeries) fyne.CanvasObject {
+	table := container.NewGridWithColumns(3 + len(series))
+	table.Add(widget.NewLabelWithStyle("Expression", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	table.Add(widget.NewLabelWithStyle("Average", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	table.Add(widget.NewLabelWithStyle("Std Dev", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	for _, s := range series {
+		table.Add(widget.NewLabelWithStyle("P(> "+s.expression+")", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	}
+
+	for i, a := range series {
+		table.Add(widget.NewLabel(a.expression))
+		table.Add(widget.NewLabel(fmt.Sprintf("%.2f", a.stats.Average)))
+		table.Add(widget.NewLabel(fmt.Sprintf("%.2f", a.stats.StdDev)))
+		for j, b := range series {
+			if i == j {
+				table.Add(widget.NewLabel("-"))
+				continue
+			}
+			chance, _ := ProbabilityGreater(a.stats, b.stats).Float64()
+			table.Add(widget.NewLabel(fmt.Sprintf("%.2f%%", chance*100)))
+		}
+	}
+	return table
+}
@@ -3,351 +3,505 @@ package main
 import (
 	"fmt"
 	"math"
-	"math/rand"
-	"regexp"
+	"math/rand/v2"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 )
 
-// init initializes the random seed
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// CalculateDice parses a dice expression and rolls it, returning the result as a tree
+// Supports formats like: 2d20, 3d6+5, 2d20H, 2d20L, 1d20+2d6, etc.
+func CalculateDice(expression string) (*RollResult, error) {
+	return defaultRoller.Roll(expression)
 }
 
-// CalculateDice parses a dice expression and returns the result
-// Supports formats like: 2d20, 3d6+5, 2d20H, 2d20L, 1d20+2d6, etc.
-func CalculateDice(expression string) (float64, string, error) {
+// rollWith parses a dice expression and rolls it with rng
+func rollWith(expression string, rng *rand.Rand) (*RollResult, error) {
 	expression = strings.TrimSpace(expression)
 	if expression == "" {
-		return 0, "", fmt.Errorf("empty expression")
+		return nil, fmt.Errorf("empty expression")
 	}
 
-	// Expand all dice notations to their rolled values
-	expanded, diceRolls, err := expandDiceNotation(expression)
+	root, err := parseDiceExpression(expression)
 	if err != nil {
-		return 0, "", err
+		return nil, err
 	}
 
-	// Evaluate the resulting mathematical expression
-	result, err := evaluateMathExpression(expanded)
+	roller := &diceRoller{expression: expression, rng: rng}
+	tree, err := roller.eval(root)
 	if err != nil {
-		return 0, "", err
+		return nil, err
 	}
 
-	return result, diceRolls, nil
+	result := &RollResult{Expression: expression, Value: tree.Value, Root: tree}
+	if opposed, ok := root.(*opposedNode); ok {
+		result.Verdict = opposed.verdict(tree.Value)
+	} else if isCondition(root) {
+		result.Verdict = strconv.FormatBool(tree.Value != 0)
+	}
+	return result, nil
 }
 
-// expandDiceNotation finds all dice notation in the expression and replaces them with rolled values
-func expandDiceNotation(expression string) (string, string, error) {
-	result := expression
-	diceRollsStr := expression
+// RollResult is a rolled expression: its value and the tree of rolls and operations
+// that reached it
+type RollResult struct {
+	Expression string    `json:"expression"`
+	Value      float64   `json:"value"`
+	Root       *RollNode `json:"root"`
+	Verdict    string    `json:"verdict,omitempty"` // who won an opposed roll, or whether a condition held
+}
 
-	// Pattern to match dice notation: [H|L]?[count]d[sides][H|L]?
-	// Examples: d20, 2d6, 3d6H, 4d8L, h2d20, l3d6, dx (where x is placeholder)
-	dicePattern := regexp.MustCompile(`([HL])?(\d+)?d(\d+|x)([HL])?`)
+// RollNode is one part of a rolled expression and the subtotal it came to. Operations
+// have their operands as children, in the order they appear in the expression.
+type RollNode struct {
+	// Kind is "number", "dice", "negate", a function such as "floor", an operator
+	// such as "+" or ">=", "and", "or", "if" or "vs"
+	Kind     string      `json:"kind"`
+	Value    float64     `json:"value"`
+	Dice     *termRoll   `json:"dice,omitempty"` // the dice of a "dice" node
+	Children []*RollNode `json:"children,omitempty"`
+
+	rolled *rolledDice // the dice as rolled, for rendering
+}
 
-	// Process all dice matches
-	matches := dicePattern.FindAllStringSubmatchIndex(result, -1)
+// String renders the result as the history shows it: the expression with each dice
+// term replaced by its individual rolls, then the verdict if there is one
+func (r *RollResult) String() string {
+	rendered := render(r.Expression, r.Root.diceNodes())
+	if r.Verdict != "" {
+		rendered += " → " + r.Verdict
+	}
+	return rendered
+}
 
-	// Process matches in reverse to maintain string indices
-	for i := len(matches) - 1; i >= 0; i-- {
-		match := matches[i]
-		start := match[0]
-		end := match[1]
+// Terms returns the record of each dice term rolled, in the order they appear in the expression
+func (r *RollResult) Terms() []termRoll {
+	var terms []termRoll
+	for _, node := range r.Root.diceNodes() {
+		terms = append(terms, *node.Dice)
+	}
+	return terms
+}
 
-		// Extract components
-		prefixModifier := ""
-		if match[2] != -1 {
-			prefixModifier = result[match[2]:match[3]]
+// diceNodes returns the dice terms rolled beneath a node, in the order they appear in the expression
+func (n *RollNode) diceNodes() []*RollNode {
+	var dice []*RollNode
+	var walk func(node *RollNode)
+	walk = func(node *RollNode) {
+		if node.rolled != nil {
+			dice = append(dice, node)
 		}
-		countStr := ""
-		if match[4] != -1 {
-			countStr = result[match[4]:match[5]]
+		for _, child := range node.Children {
+			walk(child)
 		}
-		sidesStr := result[match[6]:match[7]]
-		suffixModifier := ""
-		if match[8] != -1 {
-			suffixModifier = result[match[8]:match[9]]
+	}
+	walk(n)
+
+	sort.Slice(dice, func(i, j int) bool {
+		return dice[i].rolled.node.start < dice[j].rolled.node.start
+	})
+	return dice
+}
+
+// termRoll is the record of one rolled dice term, kept in the history for exports
+type termRoll struct {
+	Term  string    `json:"term"` // the term as written, e.g. 4d6kh3
+	Count int       `json:"count"`
+	Die   string    `json:"die"` // the die after the d: "20", "F" or "{1,1,2,3,5,8}"
+	Dice  []dieRoll `json:"dice"`
+	Total int       `json:"total"` // what the term added to the result
+}
+
+// dieRoll is the record of one die of a dice term
+type dieRoll struct {
+	Rolls    []int `json:"rolls"`              // the face of each roll, more than one when the die exploded
+	Rerolled []int `json:"rerolled,omitempty"` // faces thrown away by rerolls
+	Value    int   `json:"value"`              // the die's total after explosions
+	Score    int   `json:"score"`              // what the die adds: its value, or its successes
+	Kept     bool  `json:"kept"`
+}
+
+// record returns the record of a rolled dice term
+func (d *rolledDice) record(expression string) termRoll {
+	term := termRoll{
+		Term:  expression[d.node.start:d.node.end],
+		Count: d.node.count,
+		Die:   d.node.die,
+		Dice:  make([]dieRoll, len(d.dice)),
+		Total: d.total,
+	}
+	for i, die := range d.dice {
+		record := dieRoll{Value: die.value, Score: die.score, Kept: !die.dropped}
+		for _, roll := range die.rolls {
+			record.Rolls = append(record.Rolls, roll.face)
+			record.Rerolled = append(record.Rerolled, roll.discarded...)
 		}
+		term.Dice[i] = record
+	}
+	return term
+}
+
+// verdict names the winner of an opposed roll and the margin it won by
+func (n *opposedNode) verdict(margin float64) string {
+	switch {
+	case margin > 0:
+		return fmt.Sprintf("%s wins by %s", n.leftText, strconv.FormatFloat(margin, 'g', -1, 64))
+	case margin < 0:
+		return fmt.Sprintf("%s wins by %s", n.rightText, strconv.FormatFloat(-margin, 'g', -1, 64))
+	}
+	return "tie"
+}
+
+// diceRoller walks an expression tree, rolling each dice term it meets
+type diceRoller struct {
+	expression string
+	rng        *rand.Rand
+	rolled     []*rolledDice
+}
+
+// maxExplosions bounds how many extra rolls a single exploding die may chain
+const maxExplosions = 100
+
+// rolledDice records the individual dice of one dice term for display
+type rolledDice struct {
+	node  *diceNode
+	dice  []rolledDie
+	total int // the sum of the kept dice's scores
+}
+
+// rolledDie is a single die of a dice term
+type rolledDie struct {
+	rolls   []faceRoll // every roll of the die, more than one when it exploded
+	value   int        // the die's total after explosions
+	score   int        // what the die adds to the result: its value, or its successes
+	dropped bool       // true when discarded by a keep/drop modifier
+}
 
-		// Determine which modifier to use (priority: suffix > prefix)
-		modifier := ""
-		if suffixModifier != "" {
-			modifier = suffixModifier
-		} else if prefixModifier != "" {
-			modifier = prefixModifier
+// faceRoll is one roll of a die after any rerolls
+type faceRoll struct {
+	face      int
+	discarded []int // faces thrown away by rerolls
+}
+
+// eval evaluates a node, rolling any dice beneath it
+func (r *diceRoller) eval(node exprNode) (*RollNode, error) {
+	switch n := node.(type) {
+	case *numberNode:
+		return &RollNode{Kind: "number", Value: n.value}, nil
+
+	case *diceNode:
+		return r.rollDice(n), nil
+
+	case *unaryNode:
+		operand, err := r.eval(n.operand)
+		if err != nil {
+			return nil, err
 		}
+		return &RollNode{Kind: "negate", Value: -operand.Value, Children: []*RollNode{operand}}, nil
 
-		// Determine count (default is 1)
-		count := 1
-		if countStr != "" {
-			var err error
-			count, err = strconv.Atoi(countStr)
-			if err != nil || count <= 0 {
-				return "", "", fmt.Errorf("invalid dice count: %s", countStr)
-			}
+	case *funcNode:
+		arg, err := r.eval(n.arg)
+		if err != nil {
+			return nil, err
 		}
+		return &RollNode{Kind: n.name, Value: roundingFuncs[n.name](arg.Value), Children: []*RollNode{arg}}, nil
 
-		// Determine sides
-		var sides int
-		if sidesStr == "x" {
-			return "", "", fmt.Errorf("dx requires a number (e.g., d20). Please use a specific die like d20 or d100")
+	case *comparisonNode:
+		left, err := r.eval(n.left)
+		if err != nil {
+			return nil, err
 		}
-		var err error
-		sides, err = strconv.Atoi(sidesStr)
-		if err != nil || sides <= 0 {
-			return "", "", fmt.Errorf("invalid dice sides: %s", sidesStr)
+		right, err := r.eval(n.right)
+		if err != nil {
+			return nil, err
 		}
+		return &RollNode{Kind: n.op, Value: truth(n.holds(left.Value, right.Value)), Children: []*RollNode{left, right}}, nil
 
-		// Roll the dice
-		rolls := rollDiceSet(count, sides)
-		var rollsStr []string
-		for _, r := range rolls {
-			rollsStr = append(rollsStr, strconv.Itoa(r))
+	case *logicalNode:
+		// Both sides are always rolled so the history shows every die
+		left, err := r.eval(n.left)
+		if err != nil {
+			return nil, err
 		}
+		right, err := r.eval(n.right)
+		if err != nil {
+			return nil, err
+		}
+		return &RollNode{Kind: n.op, Value: truth(n.holds(left.Value, right.Value)), Children: []*RollNode{left, right}}, nil
 
-		// Apply modifier (H for highest, L for lowest)
-		var value float64
-		if modifier == "H" {
-			if count == 1 {
-				value = float64(rolls[0])
-			} else {
-				sort.Ints(rolls)
-				value = float64(rolls[len(rolls)-1]) // Highest
-			}
-		} else if modifier == "L" {
-			if count == 1 {
-				value = float64(rolls[0])
-			} else {
-				sort.Ints(rolls)
-				value = float64(rolls[0]) // Lowest
-			}
-		} else {
-			// Sum all rolls
-			value = 0
-			for _, roll := range rolls {
-				value += float64(roll)
-			}
+	case *ifNode:
+		// Only the chosen result is rolled, so the node has the condition and that result
+		first := len(r.rolled)
+		cond, err := r.eval(n.cond)
+		if err != nil {
+			return nil, err
+		}
+		chosen := n.otherwise
+		if n.critical != nil && r.rolledTotal(n.natural, first) == n.natural.highest() {
+			chosen = n.critical
+		} else if cond.Value != 0 {
+			chosen = n.then
+		}
+		result, err := r.eval(chosen)
+		if err != nil {
+			return nil, err
 		}
+		return &RollNode{Kind: "if", Value: result.Value, Children: []*RollNode{cond, result}}, nil
 
-		// Replace the dice notation with its value in the result string
-		result = result[:start] + strconv.FormatFloat(value, 'f', -1, 64) + result[end:]
-		diceRollsStr = diceRollsStr[:start] + fmt.Sprintf("(%dd%d: %s)", count, sides, strings.Join(rollsStr, ", ")) + diceRollsStr[end:]
-	}
+	case *opposedNode:
+		left, err := r.eval(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.eval(n.right)
+		if err != nil {
+			return nil, err
+		}
+		return &RollNode{Kind: "vs", Value: left.Value - right.Value, Children: []*RollNode{left, right}}, nil
 
-	return result, diceRollsStr, nil
-}
+	case *binaryNode:
+		left, err := r.eval(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.eval(n.right)
+		if err != nil {
+			return nil, err
+		}
 
-// rollDiceSet rolls count dice with the given number of sides
-func rollDiceSet(count int, sides int) []int {
-	rolls := make([]int, count)
-	for i := 0; i < count; i++ {
-		rolls[i] = rand.Intn(sides) + 1 // Results in 1 to sides inclusive
+		result := &RollNode{Kind: string(n.op), Children: []*RollNode{left, right}}
+		switch n.op {
+		case '+':
+			result.Value = left.Value + right.Value
+		case '-':
+			result.Value = left.Value - right.Value
+		case '*':
+			result.Value = left.Value * right.Value
+		case '/':
+			if right.Value == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			result.Value = left.Value / right.Value
+		case '^':
+			result.Value = math.Pow(left.Value, right.Value)
+		default:
+			return nil, fmt.Errorf("unknown operator: %c", n.op)
+		}
+		if math.IsInf(result.Value, 0) || math.IsNaN(result.Value) {
+			return nil, fmt.Errorf("result is not a finite number: %v", result.Value)
+		}
+		return result, nil
 	}
-	return rolls
+
+	return nil, fmt.Errorf("unknown expression node: %T", node)
 }
 
-// evaluateMathExpression evaluates a mathematical expression with +, -, *, /, and parentheses
-// Uses a recursive descent parser to handle operator precedence
-func evaluateMathExpression(expr string) (float64, error) {
-	expr = strings.TrimSpace(expr)
-	if expr == "" {
-		return 0, fmt.Errorf("empty expression")
+// rollDice rolls a dice term and sums the dice kept by its selection
+func (r *diceRoller) rollDice(n *diceNode) *RollNode {
+	dice := make([]rolledDie, n.count)
+	values := make([]int, n.count)
+	for i := range dice {
+		dice[i] = r.rollDie(n)
+		values[i] = dice[i].value
 	}
 
-	parser := &parser{expr: expr, pos: 0}
-	result, err := parser.parseExpression()
-	if err != nil {
-		return 0, err
+	dropped := selectDice(values, n.selection)
+	for i := range dice {
+		dice[i].dropped = dropped[i]
+		dice[i].score = dice[i].scoreFor(n)
 	}
 
-	parser.skipWhitespace()
-	if parser.pos < len(parser.expr) {
-		return 0, fmt.Errorf("unexpected character at position %d: '%c'", parser.pos, parser.expr[parser.pos])
+	// Sum all kept dice
+	total := 0
+	for _, die := range dice {
+		if !die.dropped {
+			total += die.score
+		}
 	}
+	rolled := &rolledDice{node: n, dice: dice, total: total}
+	r.rolled = append(r.rolled, rolled)
 
-	return result, nil
+	record := rolled.record(r.expression)
+	return &RollNode{Kind: "dice", Value: float64(total), Dice: &record, rolled: rolled}
 }
 
-// parser is a simple recursive descent parser for mathematical expressions
-type parser struct {
-	expr string
-	pos  int
+// rolledTotal returns the total rolled by a dice term since the first roll recorded at from
+func (r *diceRoller) rolledTotal(n *diceNode, from int) int {
+	for _, d := range r.rolled[from:] {
+		if d.node == n {
+			return d.total
+		}
+	}
+	return 0
 }
 
-// parseExpression handles addition and subtraction (lowest precedence)
-func (p *parser) parseExpression() (float64, error) {
-	left, err := p.parseTerm()
-	if err != nil {
-		return 0, err
+// scoreFor returns what a die adds to its term: its value, or the successes it rolled
+func (d rolledDie) scoreFor(n *diceNode) int {
+	if n.successes == nil {
+		return d.value
 	}
-
-	for {
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) {
-			break
-		}
-
-		if p.expr[p.pos] == '+' {
-			p.pos++
-			right, err := p.parseTerm()
-			if err != nil {
-				return 0, err
-			}
-			left = left + right
-		} else if p.expr[p.pos] == '-' {
-			p.pos++
-			right, err := p.parseTerm()
-			if err != nil {
-				return 0, err
-			}
-			left = left - right
-		} else {
-			break
-		}
+	if n.explode == nil || n.explode.kind == "!!" {
+		return n.successes.score(d.value)
 	}
 
-	return left, nil
+	// Exploding and penetrating dice count every roll of the chain
+	score := 0
+	for i, roll := range d.rolls {
+		score += n.successes.score(roll.shown(n, i))
+	}
+	return score
 }
 
-// parseTerm handles multiplication and division (higher precedence)
-func (p *parser) parseTerm() (float64, error) {
-	left, err := p.parseFactor()
-	if err != nil {
-		return 0, err
+// rollDie rolls one die, rolling again each time it explodes
+func (r *diceRoller) rollDie(n *diceNode) rolledDie {
+	roll := r.rollFace(n.faces, n.reroll)
+	die := rolledDie{rolls: []faceRoll{roll}, value: roll.face}
+	if n.explode == nil {
+		return die
 	}
 
-	for {
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) {
-			break
-		}
-
-		if p.expr[p.pos] == '*' {
-			p.pos++
-			right, err := p.parseFactor()
-			if err != nil {
-				return 0, err
-			}
-			left = left * right
-		} else if p.expr[p.pos] == '/' {
-			p.pos++
-			right, err := p.parseFactor()
-			if err != nil {
-				return 0, err
-			}
-			if right == 0 {
-				return 0, fmt.Errorf("division by zero")
-			}
-			left = left / right
-		} else {
-			break
+	for len(die.rolls) <= maxExplosions && roll.face >= n.explode.threshold {
+		roll = r.rollFace(n.faces, n.reroll)
+		die.rolls = append(die.rolls, roll)
+		die.value += roll.face
+		if n.explode.kind == "!p" {
+			die.value-- // Penetrating dice lose one from every extra roll
 		}
 	}
-
-	return left, nil
+	return die
 }
 
-// parseFactor handles parentheses and unary operators (highest precedence)
-func (p *parser) parseFactor() (float64, error) {
-	left, err := p.parsePower()
-	if err != nil {
-		return 0, err
+// rollFace rolls a die once, then rerolls it as long as its reroll modifier asks
+func (r *diceRoller) rollFace(faces []int, rr *reroll) faceRoll {
+	roll := faceRoll{face: r.rollFaceValue(faces)}
+	if rr == nil {
+		return roll
 	}
 
-	for {
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) {
-			break
-		}
-
-		if p.expr[p.pos] == '^' {
-			p.pos++
-			right, err := p.parseFactor()
-			if err != nil {
-				return 0, err
-			}
-			left = math.Pow(left, right)
+	for rr.matches(roll.face) {
+		next := r.rollFaceValue(faces)
+		if rr.keepBest && next < roll.face {
+			roll.discarded = append(roll.discarded, next)
 		} else {
+			roll.discarded = append(roll.discarded, roll.face)
+			roll.face = next
+		}
+		if rr.once {
 			break
 		}
 	}
-
-	return left, nil
+	return roll
 }
 
-// parsePower handles parentheses and unary operators
-func (p *parser) parsePower() (float64, error) {
-	p.skipWhitespace()
-
-	if p.pos >= len(p.expr) {
-		return 0, fmt.Errorf("unexpected end of expression")
-	}
-
-	// Handle parentheses
-	if p.expr[p.pos] == '(' {
-		p.pos++
-		result, err := p.parseExpression()
-		if err != nil {
-			return 0, err
+// format shows each roll of the die, striking out rerolled faces, marking explosions
+// with ! and marking successes and failures when the term counts them
+func (d rolledDie) format(n *diceNode) string {
+	// Exploding and penetrating dice count successes per roll, other dice per total
+	perRoll := n.successes != nil && n.explode != nil && n.explode.kind != "!!"
+
+	parts := make([]string, len(d.rolls))
+	for i, roll := range d.rolls {
+		var sb strings.Builder
+		for _, face := range roll.discarded {
+			sb.WriteString(strikethrough(strconv.Itoa(face)))
+			sb.WriteString(" ")
 		}
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) || p.expr[p.pos] != ')' {
-			return 0, fmt.Errorf("missing closing parenthesis")
+		sb.WriteString(strconv.Itoa(roll.shown(n, i)))
+		if n.explode != nil && roll.face >= n.explode.threshold {
+			sb.WriteString("!")
 		}
-		p.pos++
-		return result, nil
+		if perRoll {
+			sb.WriteString(successMark(n.successes.score(roll.shown(n, i))))
+		}
+		parts[i] = sb.String()
 	}
 
-	// Handle unary minus
-	if p.expr[p.pos] == '-' {
-		p.pos++
-		value, err := p.parseFactor()
-		if err != nil {
-			return 0, err
-		}
-		return -value, nil
+	formatted := strings.Join(parts, "+")
+	if n.successes != nil && !perRoll {
+		formatted += successMark(d.score)
 	}
+	return formatted
+}
 
-	// Parse a number
-	return p.parseNumber()
+// shown returns the value the i-th roll of a die adds to its total
+func (f faceRoll) shown(n *diceNode, i int) int {
+	if i > 0 && n.explode != nil && n.explode.kind == "!p" {
+		return f.face - 1 // Penetrating dice lose one from every extra roll
+	}
+	return f.face
 }
 
-// parseNumber parses a number (integer or float) from the expression
-func (p *parser) parseNumber() (float64, error) {
-	p.skipWhitespace()
-	start := p.pos
+// successMark flags a successful roll with * and a failed one with f
+func successMark(score int) string {
+	switch {
+	case score > 0:
+		return "*"
+	case score < 0:
+		return "f"
+	}
+	return ""
+}
 
-	for p.pos < len(p.expr) && (isDigit(p.expr[p.pos]) || p.expr[p.pos] == '.') {
-		p.pos++
+// selectDice reports which rolls are dropped by a selection, leaving the
+// rolls themselves in the order they were made
+func selectDice(rolls []int, sel *diceSelection) []bool {
+	dropped := make([]bool, len(rolls))
+	if sel == nil {
+		return dropped
 	}
 
-	if start == p.pos {
-		if p.pos < len(p.expr) {
-			return 0, fmt.Errorf("expected number at position %d, got '%c'", p.pos, p.expr[p.pos])
+	// Order the dice best-first so the first sel.keep of them are the ones kept
+	order := make([]int, len(rolls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if sel.highest {
+			return rolls[order[i]] > rolls[order[j]]
 		}
-		return 0, fmt.Errorf("expected number at end of expression")
+		return rolls[order[i]] < rolls[order[j]]
+	})
+
+	for _, i := range order[sel.keep:] {
+		dropped[i] = true
 	}
+	return dropped
+}
 
-	numStr := p.expr[start:p.pos]
-	num, err := strconv.ParseFloat(numStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid number: %s", numStr)
+// render returns the expression with each dice term replaced by its individual rolls
+func render(expression string, dice []*RollNode) string {
+	var sb strings.Builder
+	last := 0
+	for _, node := range dice {
+		d := node.rolled
+		var rollsStr []string
+		for _, die := range d.dice {
+			if die.dropped {
+				rollsStr = append(rollsStr, strikethrough(die.format(d.node)))
+			} else {
+				rollsStr = append(rollsStr, die.format(d.node))
+			}
+		}
+		sb.WriteString(expression[last:d.node.start])
+		sb.WriteString(fmt.Sprintf("(%dd%s: %s)", d.node.count, d.node.die, strings.Join(rollsStr, ", ")))
+		last = d.node.end
 	}
+	sb.WriteString(expression[last:])
 
-	return num, nil
+	return sb.String()
 }
 
-// skipWhitespace skips over whitespace characters
-func (p *parser) skipWhitespace() {
-	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t' || p.expr[p.pos] == '\n' || p.expr[p.pos] == '\r') {
-		p.pos++
+// strikethrough marks text as discarded using combining long stroke overlays
+func strikethrough(text string) string {
+	var sb strings.Builder
+	for _, ch := range text {
+		sb.WriteRune(ch)
+		sb.WriteRune('\u0336')
 	}
+	return sb.String()
 }
 
-// isDigit checks if a character is a digit
-func isDigit(ch byte) bool {
-	return ch >= '0' && ch <= '9'
+// rollFaceValue rolls a die once, returning the value of the face it lands on
+func (r *diceRoller) rollFaceValue(faces []int) int {
+	return faces[r.rng.IntN(len(faces))]
 }
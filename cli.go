@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Exit codes of the commands
+const (
+	exitOK         = 0
+	exitError      = 1 // the expression couldn't be parsed or rolled, or a file couldn't be read
+	exitUsageError = 2 // the command line was wrong
+)
+
+// commands are the commands that run in the terminal instead of opening the window
+var commands = map[string]func(args []string) int{
+	"roll":   runRoll,
+	"stats":  runStats,
+	"repl":   runREPL,
+	"serve":  runServe,
+	"verify": runVerify,
+}
+
+// runCommand runs the command named by the first argument, reporting false if there
+// is none so the window opens instead
+func runCommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK, true
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsageError, true
+	}
+	return command(args[1:]), true
+}
+
+// printUsage lists the commands
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, `usage: dicecalc [command]
+
+Without a command, dicecalc opens the calculator window.
+
+commands:
+  roll [--seed N | --secure] [--format text|json] EXPRESSION
+                                                    roll an expression, e.g. dicecalc roll "4d6kh3";
+                                                    --secure draws every die from crypto/rand
+  stats [--format table|json|csv] [--depth N] EXPRESSION
+                                                    the chance of every result, e.g. dicecalc stats "2d6+3";
+                                                    --depth sets how many times a die may explode (default 5)
+  repl [--ascii]                                    an interactive session sharing the window's history
+  serve [--addr HOST:PORT]                          answer /roll, /stats and /compare requests with JSON
+  verify [--anchor HASH] [LEDGER]                   replay the roll ledger and check its hash chain
+`)
+}
+
+// parseArgs parses flags wherever they appear among the arguments, so they can follow
+// the expression, and returns the rest joined into an expression
+func parseArgs(flags *flag.FlagSet, args []string) (string, error) {
+	var words []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return "", err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		words = append(words, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	expression := strings.TrimSpace(strings.Join(words, " "))
+	if expression == "" {
+		return "", fmt.Errorf("missing expression")
+	}
+	return expression, nil
+}
+
+// newFlagSet returns a flag set for a command whose errors are returned rather than exiting
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// usageError reports a command line mistake and returns the usage exit code
+func usageError(command string, err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "dicecalc %s: %v\n", command, err)
+	printUsage(os.Stderr)
+	return exitUsageError
+}
+
+// runRoll is the roll command: it rolls an expression and prints the dice and result
+func runRoll(args []string) int {
+	flags := newFlagSet("roll")
+	seed := flags.String("seed", "", "roll from this seed, making the same rolls every time")
+	secure := flags.Bool("secure", false, "draw every die from crypto/rand")
+	format := flags.String("format", "text", "text or json")
+	expression, err := parseArgs(flags, args)
+	if err != nil {
+		return usageError("roll", err)
+	}
+	if *format != "text" && *format != "json" {
+		return usageError("roll", fmt.Errorf("unknown format: %s", *format))
+	}
+
+	roller := defaultRoller
+	if *secure {
+		if *seed != "" {
+			return usageError("roll", fmt.Errorf("--seed and --secure can't be used together"))
+		}
+		roller = NewCryptoRoller()
+	}
+	if *seed != "" {
+		value, err := strconv.ParseUint(*seed, 10, 64)
+		if err != nil {
+			return usageError("roll", fmt.Errorf("invalid seed: %s", *seed))
+		}
+		roller = NewSeededRoller(value)
+	}
+
+	result, err := roller.Roll(expression)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dicecalc roll: %v\n", err)
+		return exitError
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintf(os.Stderr, "dicecalc roll: %v\n", err)
+			return exitError
+		}
+		return exitOK
+	}
+	fmt.Printf("%s = %s\n", result, strconv.FormatFloat(result.Value, 'g', -1, 64))
+	return exitOK
+}
+
+// runStats is the stats command: it prints the chance of every result of an expression
+func runStats(args []string) int {
+	flags := newFlagSet("stats")
+	format := flags.String("format", "table", "table, json or csv")
+	depth := flags.String("depth", strconv.Itoa(DefaultStatisticsOptions.ExplosionDepth), "how many extra rolls an exploding die may chain")
+	expression, err := parseArgs(flags, args)
+	if err != nil {
+		return usageError("stats", err)
+	}
+	options := DefaultStatisticsOptions
+	if options.ExplosionDepth, err = parseExplosionDepth(*depth); err != nil {
+		return usageError("stats", err)
+	}
+
+	var write func(io.Writer, statisticsReport) error
+	switch *format {
+	case "table":
+		write = writeStatsTable
+	case "json":
+		write = writeStatsJSON
+	case "csv":
+		write = writeStatsCSV
+	default:
+		return usageError("stats", fmt.Errorf("unknown format: %s", *format))
+	}
+
+	stats, err := CalculateDiceStatisticsWithOptions(expression, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dicecalc stats: %v\n", err)
+		return exitError
+	}
+
+	if err := write(os.Stdout, newStatisticsReport(expression, stats)); err != nil {
+		fmt.Fprintf(os.Stderr, "dicecalc stats: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// statisticsReport is the statistics of an expression as the commands print them.
+// Chances are probabilities from 0 to 1, and counts are strings since they can be
+// too large for a JSON number.
+type statisticsReport struct {
+	Expression  string  `json:"expression"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Mean        float64 `json:"mean"`
+	Median      float64 `json:"median"`
+	Mode        float64 `json:"mode"`
+	StdDev      float64 `json:"stdDev"`
+	Variance    float64 `json:"variance"`
+	P5          float64 `json:"p5"`
+	P95         float64 `json:"p95"`
+	IQR         float64 `json:"iqr"`
+	Skewness    float64 `json:"skewness"`
+	Kurtosis    float64 `json:"kurtosis"`
+	Total       string  `json:"total"` // how many ways the dice can fall
+	Successes   bool    `json:"successes,omitempty"`
+	Condition   bool    `json:"condition,omitempty"`
+	Opposed     bool    `json:"opposed,omitempty"`
+	Approximate bool    `json:"approximate,omitempty"`
+
+	// TruncatedProbability is the chance that exploding dice would have rolled past the depth
+	TruncatedProbability float64 `json:"truncatedProbability,omitempty"`
+
+	// Win is the chance a condition holds or the left side of an opposed roll wins
+	Win  *float64 `json:"win,omitempty"`
+	Tie  *float64 `json:"tie,omitempty"`
+	Lose *float64 `json:"lose,omitempty"`
+
+	Outcomes []outcomeReport `json:"outcomes"`
+}
+
+// outcomeReport is the chance of one result
+type outcomeReport struct {
+	Value   float64 `json:"value"`
+	Count   string  `json:"count"`
+	Chance  float64 `json:"chance"`
+	AtLeast float64 `json:"atLeast"`
+	AtMost  float64 `json:"atMost"`
+}
+
+// newStatisticsReport gathers the statistics of an expression for printing
+func newStatisticsReport(expression string, stats *DiceStatistics) statisticsReport {
+	report := statisticsReport{
+		Expression:  expression,
+		Min:         stats.MinValue,
+		Max:         stats.MaxValue,
+		Mean:        stats.Average,
+		Median:      stats.Median,
+		Mode:        stats.Mode,
+		StdDev:      stats.StdDev,
+		Variance:    stats.Variance,
+		P5:          stats.P5,
+		P95:         stats.P95,
+		IQR:         stats.IQR,
+		Skewness:    stats.Skewness,
+		Kurtosis:    stats.Kurtosis,
+		Total:       stats.Total.String(),
+		Successes:   stats.Successes,
+		Condition:   stats.Condition,
+		Opposed:     stats.Opposed,
+		Approximate: stats.Approximate,
+
+		TruncatedProbability: stats.TruncatedProbability,
+	}
+	if stats.Condition || stats.Opposed {
+		report.Win = &stats.Win
+	}
+	if stats.Opposed {
+		report.Tie, report.Lose = &stats.Tie, &stats.Lose
+	}
+
+	atLeast, atMost := stats.AtLeastPercentages(), stats.AtMostPercentages()
+	for _, value := range stats.GetSortedOutcomes() {
+		chance, _ := new(big.Rat).SetFrac(stats.Results[value], stats.Total).Float64()
+		report.Outcomes = append(report.Outcomes, outcomeReport{
+			Value:   value,
+			Count:   stats.Results[value].String(),
+			Chance:  chance,
+			AtLeast: atLeast[value] / 100,
+			AtMost:  atMost[value] / 100,
+		})
+	}
+	return report
+}
+
+// writeStatsTable writes the summary statistics and a table of every result for reading in a terminal
+func writeStatsTable(w io.Writer, report statisticsReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\n", report.Expression)
+	writeSummary(tw, report)
+	if report.Approximate {
+		fmt.Fprintln(tw, "Approximate: some results could not be worked out exactly")
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "Result\tCount\tChance\tAt Least\tAt Most\t")
+	for _, outcome := range report.Outcomes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n",
+			formatOutcome(outcome.Value),
+			outcome.Count,
+			formatChance(outcome.Chance),
+			formatChance(outcome.AtLeast),
+			formatChance(outcome.AtMost),
+		)
+	}
+	return tw.Flush()
+}
+
+// writeSummary writes the summary statistics, as the statistics window shows them
+func writeSummary(w io.Writer, report statisticsReport) {
+	fmt.Fprintf(w, "Average: %.2f  |  Median: %s  |  Most Common: %s  |  Std Dev: %.2f  |  Range: %s to %s\n",
+		report.Mean, formatOutcome(report.Median), formatOutcome(report.Mode),
+		report.StdDev, formatOutcome(report.Min), formatOutcome(report.Max))
+	switch {
+	case report.Opposed:
+		fmt.Fprintf(w, "Win: %s  |  Tie: %s  |  Lose: %s\n", formatChance(*report.Win), formatChance(*report.Tie), formatChance(*report.Lose))
+	case report.Condition:
+		fmt.Fprintf(w, "Chance of Success: %s\n", formatChance(*report.Win))
+	}
+	if report.TruncatedProbability > 0 {
+		fmt.Fprintf(w, "Explosion tail cut off: %.4g%%\n", report.TruncatedProbability*100)
+	}
+}
+
+// writeStatsJSON writes the statistics as one JSON object
+func writeStatsJSON(w io.Writer, report statisticsReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// writeStatsCSV writes a row for every result, for spreadsheets
+func writeStatsCSV(w io.Writer, report statisticsReport) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"value", "count", "chance", "at_least", "at_most"})
+	for _, outcome := range report.Outcomes {
+		out.Write([]string{
+			strconv.FormatFloat(outcome.Value, 'g', -1, 64),
+			outcome.Count,
+			strconv.FormatFloat(outcome.Chance, 'g', -1, 64),
+			strconv.FormatFloat(outcome.AtLeast, 'g', -1, 64),
+			strconv.FormatFloat(outcome.AtMost, 'g', -1, 64),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// formatChance formats a probability as a percentage
+func formatChance(chance float64) string {
+	return fmt.Sprintf("%.2f%%", chance*100)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"sort"
+)
+
+// Pools of dice are built from the distribution of a single die without
+// enumerating every combination: sums by repeated convolution and keep/drop
+// selections from order statistics, so large pools such as 100d100 or
+// 20d20kh10 are cheap to compute.
+
+// intDistribution is the frequency distribution of a die or pool of dice, whose outcomes are always integers
+type intDistribution map[int]*big.Int
+
+// addCount adds count ways of reaching value
+func (d intDistribution) addCount(value int, count *big.Int) {
+	if existing, ok := d[value]; ok {
+		existing.Add(existing, count)
+	} else {
+		d[value] = new(big.Int).Set(count)
+	}
+}
+
+// addProduct adds countA*countB ways of reaching value
+func (d intDistribution) addProduct(value int, countA, countB *big.Int) {
+	d.addCount(value, new(big.Int).Mul(countA, countB))
+}
+
+// total returns the number of outcomes in the distribution
+func (d intDistribution) total() *big.Int {
+	total := new(big.Int)
+	for _, count := range d {
+		total.Add(total, count)
+	}
+	return total
+}
+
+// toDistribution converts the distribution to one over arbitrary outcomes
+func (d intDistribution) toDistribution() Distribution {
+	res := make(Distribution, len(d))
+	for value, count := range d {
+		res[float64(value)] = count
+	}
+	return res
+}
+
+// addIntDist returns the distribution of the sum of two independent integer distributions.
+// It stops with ctx's error if ctx is cancelled part way through.
+func addIntDist(ctx context.Context, a, b intDistribution) (intDistribution, error) {
+	res := make(intDistribution)
+	for valA, countA := range a {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for valB, countB := range b {
+			res.addProduct(valA+valB, countA, countB)
+		}
+	}
+	return res, nil
+}
+
+// denseDist is a distribution over the consecutive values offset, offset+1, ...
+type denseDist struct {
+	offset int
+	counts []*big.Int
+}
+
+// denseFactor is how much wider than its number of values a distribution's range may be
+// for the dense form to be used. Past it, mostly empty arrays cost more than they save.
+const denseFactor = 4
+
+// valueRange returns the lowest and highest values of a non-empty distribution
+func valueRange(d intDistribution) (int, int) {
+	minVal, maxVal := 0, 0
+	first := true
+	for value := range d {
+		if first || value < minVal {
+			minVal = value
+		}
+		if first || value > maxVal {
+			maxVal = value
+		}
+		first = false
+	}
+	return minVal, maxVal
+}
+
+// isDense reports whether a distribution's values are packed closely enough for the dense form,
+// as for numbered dice. Sparse face lists such as d{1,1000000} are not.
+func isDense(d intDistribution) bool {
+	if len(d) == 0 {
+		return true
+	}
+	minVal, maxVal := valueRange(d)
+	span := maxVal - minVal
+	if span < 0 {
+		return false // The range overflows an int, as for d{-9223372036854775807,9223372036854775807}
+	}
+	return span < denseFactor*len(d)
+}
+
+// toDense converts a distribution to its dense form
+func toDense(d intDistribution) denseDist {
+	if len(d) == 0 {
+		return denseDist{}
+	}
+
+	minVal, maxVal := valueRange(d)
+	dense := denseDist{offset: minVal, counts: newCounts(maxVal - minVal + 1)}
+	for value, count := range d {
+		dense.counts[value-minVal].Set(count)
+	}
+	return dense
+}
+
+// toIntDistribution converts a dense distribution back to a map, leaving out impossible values
+func (d denseDist) toIntDistribution() intDistribution {
+	res := make(intDistribution)
+	for i, count := range d.counts {
+		if count.Sign() != 0 {
+			res[d.offset+i] = count
+		}
+	}
+	return res
+}
+
+// uniform reports whether every value in the dense range is equally likely, as for a numbered die
+func (d denseDist) uniform() bool {
+	for _, count := range d.counts {
+		if count.Cmp(d.counts[0]) != 0 {
+			return false
+		}
+	}
+	return len(d.counts) > 0
+}
+
+// convolve returns the distribution of the sum of two independent dense distributions
+func convolve(a, b denseDist) denseDist {
+	if len(a.counts) == 0 || len(b.counts) == 0 {
+		return denseDist{}
+	}
+
+	res := denseDist{offset: a.offset + b.offset, counts: newCounts(len(a.counts) + len(b.counts) - 1)}
+	product := new(big.Int)
+	for i, countA := range a.counts {
+		if countA.Sign() == 0 {
+			continue
+		}
+		for j, countB := range b.counts {
+			res.counts[i+j].Add(res.counts[i+j], product.Mul(countA, countB))
+		}
+	}
+	return res
+}
+
+// convolveUniform adds a die whose values are all equally likely to a dense distribution.
+// Each result is a sliding window sum, so the cost doesn't grow with the number of sides.
+func convolveUniform(a, die denseDist) denseDist {
+	if len(a.counts) == 0 || len(die.counts) == 0 {
+		return denseDist{}
+	}
+
+	sides := len(die.counts)
+	weight := die.counts[0]
+	res := denseDist{offset: a.offset + die.offset, counts: make([]*big.Int, len(a.counts)+sides-1)}
+
+	window := new(big.Int)
+	for i := range res.counts {
+		if i < len(a.counts) {
+			window.Add(window, a.counts[i])
+		}
+		if i >= sides {
+			window.Sub(window, a.counts[i-sides])
+		}
+		res.counts[i] = new(big.Int).Mul(window, weight)
+	}
+	return res
+}
+
+// sumPool returns the distribution of the sum of count independent dice. It stops
+// with ctx's error if ctx is cancelled part way through.
+func sumPool(ctx context.Context, die intDistribution, count int) (intDistribution, error) {
+	if !isDense(die) {
+		// Sparse dice reach few of the values in their range, so add them outcome by outcome
+		pool := intDistribution{0: big.NewInt(1)}
+		for i := 0; i < count; i++ {
+			var err error
+			if pool, err = addIntDist(ctx, pool, die); err != nil {
+				return nil, err
+			}
+		}
+		return pool, nil
+	}
+
+	dense := toDense(die)
+	uniform := dense.uniform()
+
+	pool := denseDist{offset: 0, counts: []*big.Int{big.NewInt(1)}}
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if uniform {
+			pool = convolveUniform(pool, dense)
+		} else {
+			pool = convolve(pool, dense)
+		}
+	}
+	return pool.toIntDistribution(), nil
+}
+
+// keepPool returns the distribution of the summed scores of the dice kept by sel
+// from a pool of count independent dice.
+//
+// The dice are placed from the most to the least preferred value (highest first
+// when keeping the highest). While fewer than sel.keep dice have been placed, the
+// dice placed next are kept. dp[i] holds the kept-score distribution after placing
+// i dice, and placing c of the remaining dice on a value can happen in
+// C(remaining, c) orders. It stops with ctx's error if ctx is cancelled part way through.
+func keepPool(ctx context.Context, die dieDistribution, count int, sel *diceSelection) (intDistribution, error) {
+	// Group the die's outcomes by value: each value has a weight and a score distribution
+	type valueGroup struct {
+		value  int
+		weight *big.Int
+		scores intDistribution
+	}
+	groups := make(map[int]*valueGroup)
+	for outcome, c := range die {
+		g, ok := groups[outcome.value]
+		if !ok {
+			g = &valueGroup{value: outcome.value, weight: new(big.Int), scores: make(intDistribution)}
+			groups[outcome.value] = g
+		}
+		g.weight.Add(g.weight, c)
+		g.scores.addCount(outcome.score, c)
+	}
+
+	ordered := make([]*valueGroup, 0, len(groups))
+	for _, g := range groups {
+		ordered = append(ordered, g)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if sel.highest {
+			return ordered[i].value > ordered[j].value
+		}
+		return ordered[i].value < ordered[j].value
+	})
+
+	binomials := binomialTable(count)
+
+	dp := make([]intDistribution, count+1)
+	dp[0] = intDistribution{0: big.NewInt(1)}
+	for _, g := range ordered {
+		// keptScores[r] is the score distribution of r kept dice showing this value
+		keptScores := []intDistribution{{0: big.NewInt(1)}}
+		for r := 1; r <= sel.keep; r++ {
+			scores, err := addIntDist(ctx, keptScores[r-1], g.scores)
+			if err != nil {
+				return nil, err
+			}
+			keptScores = append(keptScores, scores)
+		}
+
+		next := make([]intDistribution, count+1)
+		for placed, dist := range dp {
+			if dist == nil {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			remaining := count - placed
+			for c := 0; c <= remaining; c++ {
+				kept := min(c, max(sel.keep-placed, 0))
+				ways := new(big.Int).Exp(g.weight, big.NewInt(int64(c-kept)), nil)
+				ways.Mul(ways, binomials[remaining][c])
+				if next[placed+c] == nil {
+					next[placed+c] = make(intDistribution)
+				}
+				for sum, countA := range dist {
+					weighted := new(big.Int).Mul(countA, ways)
+					for score, countB := range keptScores[kept] {
+						next[placed+c].addProduct(sum+score, weighted, countB)
+					}
+				}
+			}
+		}
+		dp = next
+	}
+
+	return dp[count], nil
+}
+
+// binomialTable returns Pascal's triangle up to row n
+func binomialTable(n int) [][]*big.Int {
+	table := make([][]*big.Int, n+1)
+	for i := range table {
+		table[i] = make([]*big.Int, i+1)
+		table[i][0], table[i][i] = big.NewInt(1), big.NewInt(1)
+		for j := 1; j < i; j++ {
+			table[i][j] = new(big.Int).Add(table[i-1][j-1], table[i-1][j])
+		}
+	}
+	return table
+}
+
+// newCounts returns n zero counts
+func newCounts(n int) []*big.Int {
+	counts := make([]*big.Int, n)
+	for i := range counts {
+		counts[i] = new(big.Int)
+	}
+	return counts
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math/big"
+	"slices"
+	"testing"
+)
+
+// BenchmarkCalculateDiceStatistics times the pool convolution on large sums and keep pools
+func BenchmarkCalculateDiceStatistics(b *testing.B) {
+	for _, expression := range []string{"100d100", "20d20kh10", "10d20"} {
+		b.Run(expression, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := CalculateDiceStatistics(expression); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// enumerate returns how many of the equally likely ways count dice of the given sides can
+// fall give each value, by trying every one of them
+func enumerate(sides, count int, value func(dice []int) int) map[float64]*big.Int {
+	counts := map[int]int64{}
+	dice := make([]int, count)
+	for i := range dice {
+		dice[i] = 1
+	}
+	for {
+		counts[value(dice)]++
+
+		i := 0
+		for i < count && dice[i] == sides {
+			dice[i] = 1
+			i++
+		}
+		if i == count {
+			break
+		}
+		dice[i]++
+	}
+
+	res := make(map[float64]*big.Int, len(counts))
+	for v, c := range counts {
+		res[float64(v)] = big.NewInt(c)
+	}
+	return res
+}
+
+// TestExactDistributions checks the distributions worked out by convolution and order
+// statistics against every way the dice can fall
+func TestExactDistributions(t *testing.T) {
+	tests := []struct {
+		expression string
+		sides      int
+		count      int // dice enumerated, including any extra rolls a die may need
+		value      func(dice []int) int
+	}{
+		{"4d6kh3", 6, 4, func(dice []int) int {
+			sorted := slices.Sorted(slices.Values(dice))
+			return sorted[1] + sorted[2] + sorted[3]
+		}},
+		{"2d20kl1", 20, 2, func(dice []int) int {
+			return min(dice[0], dice[1])
+		}},
+		// A d6 exploding at most 5 times, the default depth, takes 6 rolls. Rolls after
+		// the chain has stopped are ignored, which keeps every chain equally likely.
+		{"d6!", 6, 6, func(dice []int) int {
+			total := 0
+			for _, roll := range dice {
+				total += roll
+				if roll != 6 {
+					break
+				}
+			}
+			return total
+		}},
+		// Each die takes a roll and the roll it is rerolled to if the first is 1 or 2
+		{"2d6ro<2", 6, 4, func(dice []int) int {
+			total := 0
+			for i := 0; i < len(dice); i += 2 {
+				if dice[i] <= 2 {
+					total += dice[i+1]
+				} else {
+					total += dice[i]
+				}
+			}
+			return total
+		}},
+		{"8d10s>=7f1", 10, 8, func(dice []int) int {
+			successes := 0
+			for _, roll := range dice {
+				if roll >= 7 {
+					successes++
+				} else if roll == 1 {
+					successes--
+				}
+			}
+			return successes
+		}},
+	}
+	for _, tt := range tests {
+		stats, err := CalculateDiceStatistics(tt.expression)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expression, err)
+		}
+		want := enumerate(tt.sides, tt.count, tt.value)
+		wantTotal := new(big.Int).Exp(big.NewInt(int64(tt.sides)), big.NewInt(int64(tt.count)), nil)
+
+		if len(stats.Results) != len(want) {
+			t.Errorf("%s: %d outcomes, want %d", tt.expression, len(stats.Results), len(want))
+		}
+		for value, count := range want {
+			got, ok := stats.Results[value]
+			// The chances must be equal: got/total = count/wantTotal
+			if !ok || new(big.Int).Mul(got, wantTotal).Cmp(new(big.Int).Mul(count, stats.Total)) != 0 {
+				t.Errorf("%s: %v comes up %v of %v times, want %v of %v", tt.expression, value, got, stats.Total, count, wantTotal)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyExport writes the roll history, oldest roll first, in one of the export formats
+type historyExport func(w io.Writer, calculations []*calculation) error
+
+// historyExports are the export formats by file extension, in the order they are offered
+var historyExports = []struct {
+	name      string
+	extension string
+	write     historyExport
+}{
+	{"CSV", ".csv", exportCSV},
+	{"JSON Lines", ".jsonl", exportJSONLines},
+	{"Markdown", ".md", exportMarkdown},
+}
+
+// exportCSV writes a row for every die rolled, so spreadsheets can work with each face.
+// die is the die after the d, as in JSON, and die_index numbers the dice of a term from 1.
+// Calculations without dice get a single row with the term and die columns left empty.
+func exportCSV(w io.Writer, calculations []*calculation) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "time", "equation", "result", "term", "count", "die", "die_index", "rolls", "rerolled", "value", "score", "kept"})
+
+	for _, c := range chronological(calculations) {
+		row := []string{strconv.Itoa(c.id), formatTime(c.time), c.equation, strings.TrimPrefix(c.result, "= ")}
+		if len(c.terms) == 0 {
+			out.Write(append(row, "", "", "", "", "", "", "", "", ""))
+			continue
+		}
+		for _, term := range c.terms {
+			for i, die := range term.Dice {
+				out.Write(append(row,
+					term.Term,
+					strconv.Itoa(term.Count),
+					term.Die,
+					strconv.Itoa(i+1),
+					joinInts(die.Rolls, " "),
+					joinInts(die.Rerolled, " "),
+					strconv.Itoa(die.Value),
+					strconv.Itoa(die.Score),
+					strconv.FormatBool(die.Kept),
+				))
+			}
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// exportJSONLines writes one JSON object per calculation, with every die of every term
+func exportJSONLines(w io.Writer, calculations []*calculation) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // Keep < and > readable in expressions such as d20>=15
+	for _, c := range chronological(calculations) {
+		err := enc.Encode(savedCalculation{
+			ID:        c.id,
+			Equation:  c.equation,
+			DiceRolls: c.diceRolls,
+			Result:    c.result,
+			Time:      c.time,
+			Terms:     c.terms,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportMarkdown writes a table with a row per calculation, listing each term's dice
+// with dropped dice struck through
+func exportMarkdown(w io.Writer, calculations []*calculation) error {
+	var sb strings.Builder
+	sb.WriteString("| Time | Equation | Dice | Result |\n")
+	sb.WriteString("| --- | --- | --- | --- |\n")
+
+	for _, c := range chronological(calculations) {
+		var terms []string
+		for _, term := range c.terms {
+			dice := make([]string, len(term.Dice))
+			for i, die := range term.Dice {
+				dice[i] = joinInts(die.Rolls, "+")
+				if !die.Kept {
+					dice[i] = "~~" + dice[i] + "~~"
+				}
+			}
+			terms = append(terms, fmt.Sprintf("%s: %s", term.Term, strings.Join(dice, ", ")))
+		}
+
+		fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n",
+			formatTime(c.time),
+			markdownCell(c.equation),
+			markdownCell(strings.Join(terms, "; ")),
+			markdownCell(strings.TrimPrefix(c.result, "= ")),
+		)
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// chronological returns the calculations oldest first; the history keeps them newest first
+func chronological(calculations []*calculation) []*calculation {
+	ordered := make([]*calculation, len(calculations))
+	for i, c := range calculations {
+		ordered[len(calculations)-1-i] = c
+	}
+	return ordered
+}
+
+// formatTime formats a roll's time for exports, leaving it empty if it wasn't recorded
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// joinInts joins numbers with a separator
+func joinInts(values []int, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, sep)
+}
+
+// markdownCell escapes the characters that would break a Markdown table cell
+func markdownCell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.ReplaceAll(text, "\n", " ")
+}
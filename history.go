@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
+	"time"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/theme"
@@ -12,6 +16,143 @@ type calculation struct {
 	equation  string
 	diceRolls string
 	result    string
+	time      time.Time
+	terms     []termRoll // the dice behind diceRolls, for exports
+}
+
+// historyFile is the file in the storage root that holds the roll history
+const historyFile = "history.json"
+
+// defaultHistoryLimit is how many rolls the history keeps until the user changes it
+const defaultHistoryLimit = 1000
+
+// savedHistory is the roll history as it is stored, newest roll first
+type savedHistory struct {
+	Limit        int                `json:"limit"` // how many rolls to keep; 0 keeps every roll
+	Calculations []savedCalculation `json:"calculations"`
+}
+
+// savedCalculation is a calculation as it is stored
+type savedCalculation struct {
+	ID        int        `json:"id"`
+	Equation  string     `json:"equation"`
+	DiceRolls string     `json:"diceRolls"`
+	Result    string     `json:"result"`
+	Time      time.Time  `json:"time"`
+	Terms     []termRoll `json:"terms,omitempty"`
+}
+
+// loadHistory reads the saved roll history, newest first, and its retention limit
+func loadHistory() ([]*calculation, int, error) {
+	history := savedHistory{Limit: defaultHistoryLimit}
+	if err := loadJSON(historyFile, &history); err != nil {
+		return nil, defaultHistoryLimit, fmt.Errorf("loading history: %w", err)
+	}
+
+	calculations := make([]*calculation, len(history.Calculations))
+	for i, c := range history.Calculations {
+		calculations[i] = &calculation{id: c.ID, equation: c.Equation, diceRolls: c.DiceRolls, result: c.Result, time: c.Time, terms: c.Terms}
+	}
+	return calculations, history.Limit, nil
+}
+
+// saveHistory writes the roll history, dropping the oldest rolls beyond the limit.
+// It returns the calculations that were kept.
+func saveHistory(calculations []*calculation, limit int) ([]*calculation, error) {
+	if limit > 0 && len(calculations) > limit {
+		calculations = calculations[:limit]
+	}
+
+	history := savedHistory{Limit: limit, Calculations: make([]savedCalculation, len(calculations))}
+	for i, c := range calculations {
+		history.Calculations[i] = savedCalculation{ID: c.id, Equation: c.equation, DiceRolls: c.diceRolls, Result: c.result, Time: c.time, Terms: c.terms}
+	}
+	if err := saveJSON(historyFile, history); err != nil {
+		return calculations, fmt.Errorf("saving history: %w", err)
+	}
+	return calculations, nil
+}
+
+// changeHistory reads the history with the storage locked, changes it and saves it, so
+// the window and REPL sessions running at once don't lose each other's rolls. It
+// returns the history and limit as they were saved.
+func changeHistory(change func(calculations []*calculation, limit int) ([]*calculation, int, error)) ([]*calculation, int, error) {
+	unlock, err := lockStorage()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer unlock()
+
+	calculations, limit, err := loadHistory()
+	if err != nil {
+		return nil, 0, err
+	}
+	if calculations, limit, err = change(calculations, limit); err != nil {
+		return nil, 0, err
+	}
+	calculations, err = saveHistory(calculations, limit)
+	return calculations, limit, err
+}
+
+// recordRoll rolls an equation and adds it to the history and the ledger, reading both
+// again first so the roll follows the newest roll made by any window or REPL session.
+// The new calculation is first in the history it returns.
+func recordRoll(equation string, profile *characterProfile, ledger *rollLedger) ([]*calculation, int, error) {
+	return changeHistory(func(calculations []*calculation, limit int) ([]*calculation, int, error) {
+		seed := defaultRoller.NewSeed()
+		if ledger != nil {
+			if err := ledger.reload(); err != nil {
+				return nil, 0, fmt.Errorf("reading ledger: %w", err)
+			}
+			seed = ledger.nextSeed()
+		}
+
+		c, rolled, err := rollCalculation(equation, calculations, profile, seed)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ledger != nil {
+			if err := ledger.append(c, seed, rolled); err != nil {
+				return nil, 0, fmt.Errorf("recording roll in ledger: %w", err)
+			}
+		}
+		return append([]*calculation{c}, calculations...), limit, nil
+	})
+}
+
+// rollCalculation rolls an equation for the history. It substitutes the profile's
+// variables, e.g. STR, and rolls from seed, which the ledger derives so it can replay the
+// roll. The calculation is numbered after the newest one in calculations.
+func rollCalculation(equation string, calculations []*calculation, profile *characterProfile, seed uint64) (*calculation, *RollResult, error) {
+	expression, bindings, err := profile.resolve(equation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rolled, err := NewSeededRoller(seed).Roll(expression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diceRolls := rolled.String()
+	// Show the variables' values so the math can be checked
+	if bindings != "" {
+		diceRolls += "  [" + bindings + "]"
+	}
+	// The newest calculation is first
+	id := 0
+	if len(calculations) > 0 {
+		id = calculations[0].id + 1
+	}
+	c := &calculation{
+		id:        id,
+		equation:  equation,
+		diceRolls: diceRolls,
+		result:    fmt.Sprintf("= %s", strconv.FormatFloat(rolled.Value, 'g', -1, 64)),
+		time:      time.Now(),
+		terms:     rolled.Terms(),
+	}
+	return c, rolled, nil
 }
 
 type historyItemRenderer struct {
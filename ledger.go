@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ledgerFile is the file in the storage root that holds the roll ledger, one entry per line
+const ledgerFile = "ledger.jsonl"
+
+// ledgerEntry is one roll in the ledger. Each entry has the seed its dice were rolled
+// from, so the roll can be replayed, and the hash of the entry before it, so no entry
+// can be changed, removed or slipped in without breaking the chain. The seed is derived
+// from a secret the roller committed to before the players chose their nonce, so neither
+// side can choose it or work it out before the roll.
+type ledgerEntry struct {
+	Index      int         `json:"index"`
+	ID         int         `json:"id"` // the calculation's id in the history
+	Time       time.Time   `json:"time"`
+	Equation   string      `json:"equation"`        // as typed
+	Expression string      `json:"expression"`      // as rolled, with variables substituted
+	Commitment string      `json:"commitment"`      // SHA-256 of Secret, shown before the roll
+	Secret     string      `json:"secret"`          // revealed by the roll
+	Nonce      string      `json:"nonce,omitempty"` // supplied by the players
+	Seed       uint64      `json:"seed"`
+	Result     *RollResult `json:"result"`
+	Previous   string      `json:"previous"` // the previous entry's hash, empty for the first
+	Hash       string      `json:"hash"`     // SHA-256 of the entry without its hash
+}
+
+// hash returns the SHA-256 of the entry's JSON with the hash left out
+func (e ledgerEntry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ledgerSeed derives an entry's seed from the previous entry's hash, the roller's secret
+// and the players' nonce. The players can't work it out before the roll since the secret
+// is kept from them until then, and the roller can't choose it since they committed to
+// the secret before the players chose the nonce. Rolling again privately gives the same
+// dice, so the only way to change a roll is to add an entry, which everyone can see.
+func ledgerSeed(previous, secret, nonce string) uint64 {
+	sum := sha256.Sum256([]byte(previous + "\n" + secret + "\n" + nonce))
+	return binary.LittleEndian.Uint64(sum[:8])
+}
+
+// commitment returns the hex SHA-256 of a secret, which can be shown without giving it away
+func commitment(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// rollLedger appends rolls to the ledger file, chaining each to the last
+type rollLedger struct {
+	path     string
+	previous string
+	next     int
+	secret   string // the next roll's secret, drawn afresh after every roll
+	nonce    string // mixed into the seed of every roll until the players give another
+}
+
+// ledgerPath returns where the ledger is kept in the storage root
+func ledgerPath() (string, error) {
+	dir, err := storageDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ledgerFile), nil
+}
+
+// openLedger opens the ledger in the storage root, reading it to find the end of the chain
+func openLedger() (*rollLedger, error) {
+	path, err := ledgerPath()
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := &rollLedger{path: path, secret: rand.Text()}
+	if err := ledger.reload(); err != nil {
+		return nil, fmt.Errorf("opening ledger: %w", err)
+	}
+	return ledger, nil
+}
+
+// reload reads the ledger's last entry again to find the end of the chain, which other
+// windows and REPL sessions may have added to. It should be called with the storage
+// locked, just before rolling the next entry.
+func (l *rollLedger) reload() error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		l.previous, l.next = "", 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Read back from the end a block at a time until the last line is whole
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	var tail []byte
+	for start := end; ; {
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 || start == 0 {
+			tail = trimmed[i+1:]
+			break
+		}
+		block := min(start, 4096)
+		start -= block
+		chunk := make([]byte, block)
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return err
+		}
+		tail = append(chunk, tail...)
+	}
+
+	if len(tail) == 0 {
+		l.previous, l.next = "", 0
+		return nil
+	}
+	var last ledgerEntry
+	if err := json.Unmarshal(tail, &last); err != nil {
+		return fmt.Errorf("reading last entry: %w", err)
+	}
+	l.previous, l.next = last.Hash, last.Index+1
+	return nil
+}
+
+// nextSeed returns the seed the next roll must be rolled from
+func (l *rollLedger) nextSeed() uint64 {
+	return ledgerSeed(l.previous, l.secret, l.nonce)
+}
+
+// commitment returns the commitment to the next roll's secret, for the players to note
+// down before they choose a nonce and to find in the roll's entry afterwards
+func (l *rollLedger) commitment() string {
+	return commitment(l.secret)
+}
+
+// head returns the hash of the newest entry, for the players to note down so the
+// ledger can't be rebuilt from before it without them noticing
+func (l *rollLedger) head() (string, error) {
+	if err := l.reload(); err != nil {
+		return "", err
+	}
+	return l.previous, nil
+}
+
+// setNonce sets the nonce the players supplied for the rolls that follow
+func (l *rollLedger) setNonce(nonce string) {
+	l.nonce = nonce
+}
+
+// append adds a calculation rolled from seed, which must be nextSeed, to the end of the ledger
+func (l *rollLedger) append(c *calculation, seed uint64, result *RollResult) error {
+	if seed != l.nextSeed() {
+		return errors.New("roll wasn't made from the ledger's next seed")
+	}
+	entry := ledgerEntry{
+		Index:      l.next,
+		ID:         c.id,
+		Time:       c.time.Round(0).UTC(),
+		Equation:   c.equation,
+		Expression: result.Expression,
+		Commitment: l.commitment(),
+		Secret:     l.secret,
+		Nonce:      l.nonce,
+		Seed:       seed,
+		Result:     result,
+		Previous:   l.previous,
+	}
+	hash, err := entry.hash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	l.previous = hash
+	l.next++
+	l.secret = rand.Text()
+	return nil
+}
+
+// readLedger calls fn with each entry of a ledger file in order
+func readLedger(path string, fn func(ledgerEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			var entry ledgerEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// verifyLedger checks every entry of a ledger file: that it follows on from the entry
+// before it, that its hash matches its contents, that its secret is the one committed to,
+// that its seed was derived from the chain and that replaying its seed rolls the same dice. Each anchor, a hash the players
+// noted down, must be the hash of an entry. It writes each problem to out and returns
+// how many entries it checked, how many had problems and how many anchors are missing.
+func verifyLedger(path string, anchors []string, out io.Writer) (checked, failed, missing int, err error) {
+	previous := ""
+	unseen := map[string]bool{}
+	for _, anchor := range anchors {
+		unseen[anchor] = true
+	}
+	err = readLedger(path, func(e ledgerEntry) error {
+		var problems []string
+		if e.Index != checked {
+			problems = append(problems, fmt.Sprintf("expected entry %d", checked))
+		}
+		if e.Previous != previous {
+			problems = append(problems, "doesn't follow on from the entry before it")
+		}
+		if hash, err := e.hash(); err != nil || hash != e.Hash {
+			problems = append(problems, "hash doesn't match its contents")
+		}
+		if commitment(e.Secret) != e.Commitment {
+			problems = append(problems, "secret doesn't match its commitment")
+		}
+		if e.Seed != ledgerSeed(e.Previous, e.Secret, e.Nonce) {
+			problems = append(problems, "seed wasn't derived from the entry before it, the secret and the nonce")
+		}
+		if problem := replayProblem(e); problem != "" {
+			problems = append(problems, problem)
+		}
+
+		for _, problem := range problems {
+			fmt.Fprintf(out, "entry %d (%s): %s\n", e.Index, e.Equation, problem)
+		}
+		if len(problems) > 0 {
+			failed++
+		}
+		checked++
+		previous = e.Hash
+		delete(unseen, e.Hash)
+		return nil
+	})
+	for _, anchor := range anchors {
+		if unseen[anchor] {
+			fmt.Fprintf(out, "no entry has the hash %s\n", anchor)
+			missing++
+		}
+	}
+	return checked, failed, missing, err
+}
+
+// replayProblem rolls an entry's expression again from its seed, describing how the
+// replay differs from the recorded result, or returning "" if it is the same
+func replayProblem(e ledgerEntry) string {
+	replayed, err := NewSeededRoller(e.Seed).Roll(e.Expression)
+	if err != nil {
+		return fmt.Sprintf("can't replay: %v", err)
+	}
+	if e.Result == nil {
+		return "has no result"
+	}
+
+	want, err := json.Marshal(replayed)
+	if err != nil {
+		return fmt.Sprintf("can't replay: %v", err)
+	}
+	got, err := json.Marshal(e.Result)
+	if err != nil {
+		return fmt.Sprintf("can't replay: %v", err)
+	}
+	if string(want) != string(got) {
+		return fmt.Sprintf("recorded %v but its seed rolls %s = %v", e.Result.Value, replayed, replayed.Value)
+	}
+	return ""
+}
+
+// runVerify is the verify command: it checks the ledger in the storage root, or the
+// ledger file given, and returns the exit code
+func runVerify(args []string) int {
+	flags := newFlagSet("verify")
+	var anchors []string
+	flags.Func("anchor", "a hash noted down from the ledger, which must still be in it", func(hash string) error {
+		anchors = append(anchors, strings.ToLower(hash))
+		return nil
+	})
+	if err := flags.Parse(args); err != nil {
+		return usageError("verify", err)
+	}
+	if flags.NArg() > 1 {
+		return usageError("verify", fmt.Errorf("unexpected argument: %s", flags.Arg(1)))
+	}
+
+	path := ""
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	} else {
+		var err error
+		if path, err = ledgerPath(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	checked, failed, missing, err := verifyLedger(path, anchors, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading %s: %v\n", path, err)
+		return 1
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d rolls failed verification\n", failed, checked)
+	}
+	if missing > 0 {
+		fmt.Printf("%d of %d anchors are missing, so the ledger was rebuilt since they were noted\n", missing, len(anchors))
+	}
+	if failed > 0 || missing > 0 {
+		return 1
+	}
+	fmt.Printf("verified %d rolls\n", checked)
+	return 0
+}
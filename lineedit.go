@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// inputHistoryFile is the file in the storage root that keeps the lines typed into the REPL
+const inputHistoryFile = "repl_history"
+
+// inputHistoryLimit is how many typed lines are kept between sessions
+const inputHistoryLimit = 1000
+
+// lineReader reads the REPL's input a line at a time
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// scannerReader reads lines without editing, for input that isn't a terminal
+type scannerReader struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func (s *scannerReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(s.out, prompt)
+	if !s.in.Scan() {
+		if err := s.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.in.Text(), nil
+}
+
+// lineEditor reads lines from a terminal in raw mode, so they can be edited with the
+// arrow keys and the usual Emacs keys, and earlier lines recalled with up and down.
+// The lines are kept in a file so they can be recalled in later sessions too.
+type lineEditor struct {
+	fd      int
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+	path    string // where the history is kept, or empty if it can't be
+}
+
+// newLineEditor returns a line editor for a terminal, with the input history of earlier
+// sessions. If the history can't be read, the editor still works but returns the error.
+func newLineEditor(in *os.File, out io.Writer) (*lineEditor, error) {
+	e := &lineEditor{fd: int(in.Fd()), in: bufio.NewReader(in), out: out}
+	dir, err := storageDir()
+	if err != nil {
+		return e, err
+	}
+	e.path = filepath.Join(dir, inputHistoryFile)
+	data, err := os.ReadFile(e.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		e.path = ""
+		return e, fmt.Errorf("loading input history: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > inputHistoryLimit {
+		e.history = e.history[len(e.history)-inputHistoryLimit:]
+		if err := os.WriteFile(e.path, []byte(strings.Join(e.history, "\n")+"\n"), 0o644); err != nil {
+			return e, fmt.Errorf("saving input history: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// readLine reads a line, returning io.EOF for Ctrl+D on an empty line. Ctrl+C abandons
+// the line and returns an empty one.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var line []rune
+	cursor := 0
+	recalled := len(e.history) // the history entry shown; len(e.history) is the new line
+	draft := ""                // the new line, kept while older lines are shown
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - cursor; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(e.history) || i == recalled {
+			return
+		}
+		if recalled == len(e.history) {
+			draft = string(line)
+		}
+		recalled = i
+		if i == len(e.history) {
+			line = []rune(draft)
+		} else {
+			line = []rune(e.history[i])
+		}
+		cursor = len(line)
+	}
+
+	redraw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			text := string(line)
+			e.remember(text)
+			return text, nil
+		case 3: // Ctrl+C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", nil
+		case 4: // Ctrl+D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if cursor < len(line) {
+				line = append(line[:cursor], line[cursor+1:]...)
+			}
+		case 127, 8: // Backspace
+			if cursor > 0 {
+				line = append(line[:cursor-1], line[cursor:]...)
+				cursor--
+			}
+		case 1: // Ctrl+A
+			cursor = 0
+		case 5: // Ctrl+E
+			cursor = len(line)
+		case 2: // Ctrl+B
+			cursor = max(cursor-1, 0)
+		case 6: // Ctrl+F
+			cursor = min(cursor+1, len(line))
+		case 11: // Ctrl+K
+			line = line[:cursor]
+		case 21: // Ctrl+U
+			line = line[cursor:]
+			cursor = 0
+		case 23: // Ctrl+W deletes the word before the cursor
+			start := cursor
+			for start > 0 && unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			line = append(line[:start], line[cursor:]...)
+			cursor = start
+		case 16: // Ctrl+P
+			recall(recalled - 1)
+		case 14: // Ctrl+N
+			recall(recalled + 1)
+		case 27: // Escape starts the sequence sent by the arrow keys, Home, End and Delete
+			switch e.readEscape() {
+			case "A":
+				recall(recalled - 1)
+			case "B":
+				recall(recalled + 1)
+			case "C":
+				cursor = min(cursor+1, len(line))
+			case "D":
+				cursor = max(cursor-1, 0)
+			case "H", "1~", "7~":
+				cursor = 0
+			case "F", "4~", "8~":
+				cursor = len(line)
+			case "3~":
+				if cursor < len(line) {
+					line = append(line[:cursor], line[cursor+1:]...)
+				}
+			}
+		default:
+			if !unicode.IsPrint(r) {
+				continue
+			}
+			line = append(line[:cursor], append([]rune{r}, line[cursor:]...)...)
+			cursor++
+		}
+		redraw()
+	}
+}
+
+// readEscape reads the rest of an escape sequence such as ESC [ A, returning its
+// parameters and final character, e.g. "A" or "3~"
+func (e *lineEditor) readEscape() string {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return ""
+	}
+	var sequence []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		sequence = append(sequence, r)
+		if r >= 0x40 && r <= 0x7e { // the final character
+			return string(sequence)
+		}
+	}
+}
+
+// remember adds a line to the input history, unless it is empty or repeats the last line.
+// The history is only a convenience, so failing to save it isn't reported.
+func (e *lineEditor) remember(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0o755); err != nil {
+		return
+	}
+	f, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
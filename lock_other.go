@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package main
+
+import "os"
+
+// lockFile does nothing, as this system has no file locks to take
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing, like lockFile
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// macro is a saved formula with a name, such as "Longsword" for d20+STR+PROF
+type macro struct {
+	Name    string `json:"name"`
+	Formula string `json:"formula"`
+}
+
+// macrosFile is the file in the storage root that holds the macro library
+const macrosFile = "macros.json"
+
+// loadMacros reads the saved macro library, which is empty until a macro is saved
+func loadMacros() ([]macro, error) {
+	var macros []macro
+	if err := loadJSON(macrosFile, &macros); err != nil {
+		return nil, fmt.Errorf("loading macros: %w", err)
+	}
+	return macros, nil
+}
+
+// saveMacros writes the macro library
+func saveMacros(macros []macro) error {
+	if err := saveJSON(macrosFile, macros); err != nil {
+		return fmt.Errorf("saving macros: %w", err)
+	}
+	return nil
+}
+
+// addMacro adds a macro to the library, replacing the formula of one with the same name,
+// and keeps the library sorted by name
+func addMacro(macros []macro, name, formula string) ([]macro, error) {
+	name, formula = strings.TrimSpace(name), strings.TrimSpace(formula)
+	if name == "" {
+		return nil, fmt.Errorf("macro needs a name")
+	}
+	if formula == "" {
+		return nil, fmt.Errorf("macro needs a formula")
+	}
+
+	macros = slices.Clone(macros)
+	for i := range macros {
+		if macros[i].Name == name {
+			macros[i].Formula = formula
+			return macros, nil
+		}
+	}
+
+	macros = append(macros, macro{Name: name, Formula: formula})
+	slices.SortFunc(macros, func(a, b macro) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return macros, nil
+}
+
+// macroItem shows a macro in the macro list, with buttons to graph and delete it
+type macroItem struct {
+	widget.BaseWidget
+	nameLabel    *widget.Label
+	formulaLabel *widget.Label
+	statsButton  *widget.Button
+	deleteButton *widget.Button
+}
+
+func newMacroItem() *macroItem {
+	item := &macroItem{
+		nameLabel:    widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		formulaLabel: widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Italic: true}),
+		statsButton:  widget.NewButton("📊", nil),
+		deleteButton: widget.NewButtonWithIcon("", theme.DeleteIcon(), nil),
+	}
+	item.nameLabel.Truncation = fyne.TextTruncateEllipsis
+	item.formulaLabel.Truncation = fyne.TextTruncateEllipsis
+	item.ExtendBaseWidget(item)
+	return item
+}
+
+func (m *macroItem) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(
+		nil,
+		nil,
+		nil,
+		container.NewHBox(m.statsButton, m.deleteButton),
+		container.NewVBox(m.nameLabel, m.formulaLabel),
+	))
+}
+
+// SetMacro shows a macro, graphing it with onStats and removing it with onDelete
+func (m *macroItem) SetMacro(mac macro, onStats, onDelete func()) {
+	m.nameLabel.SetText(mac.Name)
+	m.formulaLabel.SetText(mac.Formula)
+	m.statsButton.OnTapped = onStats
+	m.deleteButton.OnTapped = onDelete
+}
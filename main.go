@@ -2,23 +2,62 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
 func main() {
-	myApp := app.New()
+	// Commands such as roll and stats run in the terminal without the window
+	if code, ok := runCommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
+	myApp := app.NewWithID(appID)
 	myWindow := myApp.NewWindow("Dice Statistics Calculator")
 
-	var calculations []*calculation
 	var historyList *widget.List
 
+	// Roll history, saved between sessions
+	calculations, historyLimit, err := loadHistory()
+	if err != nil {
+		fyne.LogError("Failed to load history", err)
+	}
+	// The history is read again before every change, so rolls made in the REPL while the
+	// window is open aren't overwritten
+	changeCalculations := func(change func([]*calculation, int) ([]*calculation, int, error)) {
+		changed, limit, err := changeHistory(change)
+		if err != nil {
+			fyne.LogError("Failed to save history", err)
+			return
+		}
+		calculations, historyLimit = changed, limit
+		historyList.Refresh()
+	}
+
+	// Ledger of every roll, hash chained so the rolls can be verified
+	ledger, err := openLedger()
+	if err != nil {
+		fyne.LogError("Failed to open ledger", err)
+	}
+
+	// Character profile whose variables can be used in expressions, e.g. d20+STR, saved
+	// between sessions and shared with the REPL
+	profile, err := loadProfile()
+	if err != nil {
+		fyne.LogError("Failed to load profile", err)
+	}
+	myWindow.SetTitle(windowTitle(profile))
+
 	// Dice input bar
 	diceInputEntry := newCustomEntry(fyne.CurrentApp().Settings().Theme().Size(theme.SizeNameText) * 2)
 	diceInputEntry.SetPlaceHolder("e.g., 2d20H, 3d6+5")
@@ -37,31 +76,69 @@ func main() {
 		},
 	)
 
-	roll := func() {
-		diceInput := strings.TrimSpace(diceInputEntry.Text)
+	rollExpression := func(diceInput string) {
+		diceInput = strings.TrimSpace(diceInput)
 		if diceInput == "" {
 			return
 		}
 
-		result, diceRolls, err := CalculateDice(diceInput)
+		rolled, limit, err := recordRoll(diceInput, profile, ledger)
 		if err != nil {
-			// TODO: show error to user
+			dialog.ShowError(err, myWindow)
 		} else {
-			id := 0
-			if len(calculations) > 0 {
-				id = calculations[len(calculations)-1].id + 1
-			}
-			c := &calculation{
-				id:        id,
-				equation:  diceInput,
-				diceRolls: diceRolls,
-				result:    fmt.Sprintf("= %s", strconv.FormatFloat(result, 'g', -1, 64)),
-			}
-			calculations = append([]*calculation{c}, calculations...)
+			calculations, historyLimit = rolled, limit
 			historyList.Refresh()
 		}
 	}
 
+	roll := func() {
+		rollExpression(diceInputEntry.Text)
+	}
+
+	showStatistics := func(diceInput string) {
+		diceInput = strings.TrimSpace(diceInput)
+		if diceInput == "" {
+			return
+		}
+		expression, _, err := profile.resolve(diceInput)
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		ShowStatisticsWindow(expression)
+	}
+
+	// Macro library, saved between sessions
+	macros, err := loadMacros()
+	if err != nil {
+		fyne.LogError("Failed to load macros", err)
+	}
+	var macroList *widget.List
+	macroList = widget.NewList(
+		func() int {
+			return len(macros)
+		},
+		func() fyne.CanvasObject {
+			return newMacroItem()
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			o.(*macroItem).SetMacro(macros[i], func() {
+				showStatistics(macros[i].Formula)
+			}, func() {
+				macros = slices.Delete(macros, i, i+1)
+				if err := saveMacros(macros); err != nil {
+					dialog.ShowError(err, myWindow)
+				}
+				macroList.Refresh()
+			})
+		},
+	)
+	// Tapping a macro rolls it
+	macroList.OnSelected = func(i widget.ListItemID) {
+		rollExpression(macros[i].Formula)
+		macroList.UnselectAll()
+	}
+
 	// Roll button
 	rollButton := newCustomButton2WithImportance("ROLL", widget.HighImportance, roll)
 
@@ -128,11 +205,7 @@ func main() {
 			diceInputEntry.SetText(diceInputEntry.Text + "+")
 		}),
 		newCustomButton2("📊", func() {
-			diceInput := strings.TrimSpace(diceInputEntry.Text)
-			if diceInput == "" {
-				return
-			}
-			ShowStatisticsWindow(diceInput)
+			showStatistics(diceInputEntry.Text)
 			roll()
 		}),
 		newCustomButton2(".", func() {
@@ -153,19 +226,145 @@ func main() {
 		buttonsContainer.Add(button)
 	}
 
+	// Profile button to edit the character's variables
+	profileButton := widget.NewButtonWithIcon("", theme.AccountIcon(), func() {
+		showProfileDialog(profile, myWindow)
+	})
+
+	// Save button to add the input bar's formula to the macro library
+	saveMacroButton := widget.NewButtonWithIcon("", theme.ContentAddIcon(), func() {
+		formula := strings.TrimSpace(diceInputEntry.Text)
+		if formula == "" {
+			return
+		}
+		nameEntry := widget.NewEntry()
+		nameEntry.SetPlaceHolder("e.g. Longsword")
+		dialog.ShowForm("Save Macro: "+formula, "Save", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Name", nameEntry),
+		}, func(save bool) {
+			if !save {
+				return
+			}
+			updated, err := addMacro(macros, nameEntry.Text, formula)
+			if err == nil {
+				err = saveMacros(updated)
+			}
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			macros = updated
+			macroList.Refresh()
+		}, myWindow)
+	})
+
+	historyAndMacros := container.NewHSplit(historyList, macroList)
+	historyAndMacros.Offset = 0.65
+
 	topContent := container.NewBorder(
-		diceInputEntry,
+		container.NewBorder(nil, nil, nil, container.NewHBox(saveMacroButton, profileButton), diceInputEntry),
 		nil,
 		nil,
 		nil,
-		historyList,
+		historyAndMacros,
 	)
 
 	split := container.NewVSplit(topContent, buttonsContainer)
 	split.Offset = 0.5 // Start with a 50/50 split
 
+	// History menu to export the rolls, set how many are kept and clear them
+	var historyMenuItems []*fyne.MenuItem
+	for _, export := range historyExports {
+		historyMenuItems = append(historyMenuItems, fyne.NewMenuItem("Export as "+export.name+"...", func() {
+			save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+				if err != nil {
+					dialog.ShowError(err, myWindow)
+					return
+				}
+				if writer == nil {
+					return // Cancelled
+				}
+				defer writer.Close()
+				if err := export.write(writer, calculations); err != nil {
+					dialog.ShowError(fmt.Errorf("exporting history: %w", err), myWindow)
+				}
+			}, myWindow)
+			save.SetFileName("history" + export.extension)
+			save.SetFilter(storage.NewExtensionFileFilter([]string{export.extension}))
+			save.Show()
+		}))
+	}
+	historyMenuItems = append(historyMenuItems, fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Retention Limit...", func() {
+			limitEntry := widget.NewEntry()
+			limitEntry.SetText(strconv.Itoa(historyLimit))
+			limitEntry.Validator = func(text string) error {
+				if limit, err := strconv.Atoi(text); err != nil || limit < 0 {
+					return fmt.Errorf("enter a whole number of rolls, or 0 to keep every roll")
+				}
+				return nil
+			}
+			dialog.ShowForm("History Retention", "Save", "Cancel", []*widget.FormItem{
+				widget.NewFormItem("Rolls to keep", limitEntry),
+			}, func(save bool) {
+				if !save {
+					return
+				}
+				limit, _ := strconv.Atoi(limitEntry.Text)
+				changeCalculations(func(calculations []*calculation, _ int) ([]*calculation, int, error) {
+					return calculations, limit, nil
+				})
+			}, myWindow)
+		}),
+		fyne.NewMenuItem("Clear History", func() {
+			dialog.ShowConfirm("Clear History", "Delete every roll in the history?", func(clear bool) {
+				if !clear {
+					return
+				}
+				changeCalculations(func(_ []*calculation, limit int) ([]*calculation, int, error) {
+					return nil, limit, nil
+				})
+			}, myWindow)
+		}),
+	)
+
+	// Dice menu for the players to note down the next roll's commitment and the newest
+	// roll's hash, and to supply the nonce mixed into every roll's seed
+	nonceItem := fyne.NewMenuItem("Players' Nonce...", func() {
+		if ledger == nil {
+			dialog.ShowError(fmt.Errorf("the roll ledger couldn't be opened"), myWindow)
+			return
+		}
+		head, err := ledger.head()
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		nonceEntry := widget.NewEntry()
+		nonceEntry.SetText(ledger.nonce)
+		nonceEntry.SetPlaceHolder("any text the players choose")
+		commitmentLabel := widget.NewLabel(ledger.commitment())
+		commitmentLabel.Wrapping = fyne.TextWrapBreak
+		headLabel := widget.NewLabel(head)
+		headLabel.Wrapping = fyne.TextWrapBreak
+		dialog.ShowForm("Players' Nonce", "Save", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Next roll's commitment", commitmentLabel),
+			widget.NewFormItem("Nonce", nonceEntry),
+			widget.NewFormItem("Newest roll's hash", headLabel),
+		}, func(save bool) {
+			if save {
+				ledger.setNonce(nonceEntry.Text)
+			}
+		}, myWindow)
+	})
+
+	myWindow.SetMainMenu(fyne.NewMainMenu(
+		fyne.NewMenu("History", historyMenuItems...),
+		fyne.NewMenu("Dice", nonceItem),
+	))
+
 	myWindow.SetContent(split)
-	myWindow.Resize(fyne.NewSize(400, 600))
+	myWindow.Resize(fyne.NewSize(600, 600))
 	myWindow.ShowAndRun()
 }
 
@@ -174,3 +373,45 @@ func createCalcButton(label string, entry *customEntry) *customButton2 {
 		entry.SetText(entry.Text + label)
 	})
 }
+
+// showProfileDialog lets the user name their character and edit its variables, one per line
+func showProfileDialog(profile *characterProfile, window fyne.Window) {
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(profile.name)
+	nameEntry.SetPlaceHolder("Character name")
+
+	variablesEntry := widget.NewMultiLineEntry()
+	variablesEntry.SetText(profile.definitions())
+	variablesEntry.SetPlaceHolder("STR=4\nPROF=3")
+	variablesEntry.SetMinRowsVisible(8)
+
+	content := container.NewBorder(nameEntry, nil, nil, nil, variablesEntry)
+	dialog.ShowCustomConfirm("Character Profile", "Save", "Cancel", content, func(save bool) {
+		if !save {
+			return
+		}
+		variables, err := parseVariables(variablesEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		saved, err := changeProfile(func(saved *characterProfile) {
+			saved.name = strings.TrimSpace(nameEntry.Text)
+			saved.variables = variables
+		})
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		*profile = *saved
+		window.SetTitle(windowTitle(profile))
+	}, window)
+}
+
+// windowTitle returns the window's title, with the character's name if it has one
+func windowTitle(profile *characterProfile) string {
+	if profile.name == "" {
+		return "Dice Statistics Calculator"
+	}
+	return "Dice Statistics Calculator - " + profile.name
+}
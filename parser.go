@@ -0,0 +1,905 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// exprNode is a node in a parsed dice expression. The roller in calculations.go
+// and the statistics engine in statistics.go walk the same tree, so any
+// expression that can be rolled also has a matching distribution.
+type exprNode interface {
+	exprNode()
+}
+
+// numberNode is a numeric literal such as 5 or .5
+type numberNode struct {
+	value float64
+}
+
+// diceNode is a dice term such as 2d20H, 4d6kh3, 4dF or d{1,1,2,3,5,8}
+type diceNode struct {
+	count     int
+	die       string         // the die as written after the d: "20", "F" or "{1,1,2,3,5,8}"
+	faces     []int          // the value of every face; 1 to N for a numbered die
+	reroll    *reroll        // nil when no faces are rerolled
+	explode   *explosion     // nil when the dice don't explode
+	selection *diceSelection // nil sums every die
+	successes *successCount  // nil sums the dice instead of counting successes
+	start     int            // byte offset of the term in the source expression
+	end       int
+}
+
+// comparePoint matches die results against a target
+type comparePoint struct {
+	compare byte // '<' matches target or lower, '>' target or higher, '=' exactly target
+	target  int
+}
+
+// matches reports whether a die result meets the compare point
+func (c comparePoint) matches(value int) bool {
+	switch c.compare {
+	case '<':
+		return value <= c.target
+	case '>':
+		return value >= c.target
+	default:
+		return value == c.target
+	}
+}
+
+// reroll rolls a die again when it lands on a matching face. It applies to
+// every roll of a die, including the extra rolls of an explosion.
+type reroll struct {
+	comparePoint
+	once     bool // reroll a single time rather than until the face no longer matches
+	keepBest bool // reroll once and keep the better of the two rolls
+}
+
+// successCount makes a dice term count the dice that meet a target instead of summing them.
+// Exploding dice count every roll of the chain, while compounding dice count their total.
+type successCount struct {
+	success  comparePoint
+	failure  comparePoint
+	failures bool // true when dice meeting failure subtract a success
+}
+
+// score returns how many successes a die result is worth: 1, 0 or -1 for a failure
+func (s *successCount) score(value int) int {
+	if s.success.matches(value) {
+		return 1
+	}
+	if s.failures && s.failure.matches(value) {
+		return -1
+	}
+	return 0
+}
+
+// explosion rolls another die whenever a roll meets its threshold, adding it to the die's total.
+// Keep and drop rank each die by its total after explosions.
+type explosion struct {
+	kind      string // "!" explode, "!!" compound or "!p" penetrate (each extra roll is one less)
+	threshold int    // rolls at or above this explode
+}
+
+// diceSelection keeps only the highest or lowest dice of a pool.
+// H and L keep a single die; khN, klN, dhN and dlN are normalized to a keep count.
+type diceSelection struct {
+	highest bool
+	keep    int
+}
+
+// unaryNode is a negated operand
+type unaryNode struct {
+	op      byte
+	operand exprNode
+}
+
+// funcNode is a rounding function applied to its argument, such as floor(d20/2)
+type funcNode struct {
+	name string // "floor", "ceil", "round" or "exact"
+	arg  exprNode
+}
+
+// binaryNode is an arithmetic operation between two operands
+type binaryNode struct {
+	op    byte
+	left  exprNode
+	right exprNode
+}
+
+// comparisonNode compares two operands, giving 1 when the comparison holds and 0 when it doesn't
+type comparisonNode struct {
+	op    string // ">=", "<=", ">", "<", "==" or "!="
+	left  exprNode
+	right exprNode
+}
+
+// holds reports whether the comparison holds between the values of its operands
+func (n *comparisonNode) holds(left, right float64) bool {
+	switch n.op {
+	case ">=":
+		return left >= right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	case "<":
+		return left < right
+	case "==":
+		return left == right
+	default: // "!="
+		return left != right
+	}
+}
+
+// logicalNode combines two conditions with "and" or "or", treating any non-zero value as true
+type logicalNode struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+// holds reports whether the combined condition holds between the values of its operands
+func (n *logicalNode) holds(left, right float64) bool {
+	if n.op == "and" {
+		return left != 0 && right != 0
+	}
+	return left != 0 || right != 0
+}
+
+// isCondition reports whether a node evaluates to true or false rather than a number
+func isCondition(node exprNode) bool {
+	switch node.(type) {
+	case *comparisonNode, *logicalNode:
+		return true
+	}
+	return false
+}
+
+// truth converts a condition's result to 1 for true and 0 for false
+func truth(holds bool) float64 {
+	if holds {
+		return 1
+	}
+	return 0
+}
+
+// ifNode chooses between two expressions on a condition, such as if(d20+5>=15, 2d6+3, 0).
+// With a critical expression, as in if(d20+5>=15, 2d6+3, 0, 4d6+3), the first dice term
+// of the condition rolling its highest total (a natural 20) gives the critical result instead.
+type ifNode struct {
+	cond      exprNode
+	then      exprNode
+	otherwise exprNode
+	critical  exprNode  // nil when the condition can't crit
+	natural   *diceNode // the dice term that crits on its highest total
+}
+
+// opposedNode is an opposed roll such as d20+5 vs d20+3. Its value is the margin
+// by which the left side beats the right, negative when the right side wins.
+type opposedNode struct {
+	left      exprNode
+	right     exprNode
+	leftText  string // each side as written, to name the winner
+	rightText string
+}
+
+func (*numberNode) exprNode()     {}
+func (*diceNode) exprNode()       {}
+func (*unaryNode) exprNode()      {}
+func (*funcNode) exprNode()       {}
+func (*binaryNode) exprNode()     {}
+func (*comparisonNode) exprNode() {}
+func (*logicalNode) exprNode()    {}
+func (*ifNode) exprNode()         {}
+func (*opposedNode) exprNode()    {}
+
+// Regex patterns for tokens
+var (
+	// Dice notation: [H|L]?[count]d[sides|F|{faces}] followed by any modifiers below
+	// Examples: d20, 2d6, H2d20, L3d6, 4dF, d{-2,0,0,2}, dx (where x is placeholder)
+	dicePattern = regexp.MustCompile(`^([HL])?(\d*)d(\d+|x|F|\{[^}]*\})`)
+	// Reroll modifiers: r (until), ro (once) and rk (once, keep best) with <N, >N or =N
+	rerollPattern = regexp.MustCompile(`^r([ok]?)([<>=])(\d+)`)
+	// Explosion modifiers: !, !!, !p, each with an optional >N threshold. A ! followed
+	// by = is a != comparison instead.
+	explodePattern = regexp.MustCompile(`^(!!|!p|!)(>(\d+))?`)
+	// Success targets: sN, s>=N or s<=N. A bare >=N after dice is a comparison instead.
+	successPattern = regexp.MustCompile(`^s(>=|<=)?(\d+)`)
+	// Failure targets: fN, f<=N or f>=N
+	failurePattern = regexp.MustCompile(`^f(>=|<=)?(\d+)`)
+	// Keep/drop modifiers: H, L, khN, klN, dhN, dlN
+	keepPattern = regexp.MustCompile(`^([HL]|[kd][hl]\d+)`)
+	// Numbers with an optional decimal part, including forms like 5. and .5
+	numberPattern = regexp.MustCompile(`^(\d+(\.\d*)?|\.\d+)`)
+	// Rounding functions: floor(, ceil(, round( and exact(
+	funcPattern = regexp.MustCompile(`^(floor|ceil|round|exact)\(`)
+	// Conditionals: if(condition, then, else) with an optional critical result
+	ifPattern = regexp.MustCompile(`^if\(`)
+)
+
+// roundingFuncs maps each rounding function to what it does to a value.
+// exact leaves fractions alone and only makes the intent explicit.
+var roundingFuncs = map[string]func(float64) float64{
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"round": math.Round,
+	"exact": func(v float64) float64 { return v },
+}
+
+// parseDiceExpression parses a dice expression into an expression tree
+//
+// Grammar, from lowest to highest precedence:
+//
+//	opposed    = or [ "vs" or ]
+//	or         = and { "or" and }
+//	and        = comparison { "and" comparison }
+//	comparison = expression [ (">=" | "<=" | "==" | "!=" | ">" | "<") expression ]
+//	expression = term { ("+" | "-") term }
+//	term       = unary { ("*" | "/") unary | unary }   (juxtaposition multiplies)
+//	unary      = "-" unary | power
+//	power      = primary [ "^" unary ]                  (right-associative)
+//	primary    = "(" or ")" | function "(" or ")" | ifexpr | dice | number
+//	function   = "floor" | "ceil" | "round" | "exact"
+//	ifexpr     = "if(" or "," or "," or [ "," or ] ")"
+func parseDiceExpression(expression string) (exprNode, error) {
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &parser{expr: expression, pos: 0}
+	node, err := p.parseOpposed()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipWhitespace()
+	if p.pos < len(p.expr) {
+		return nil, fmt.Errorf("unexpected character at position %d: '%c'", p.pos, p.expr[p.pos])
+	}
+
+	return node, nil
+}
+
+// parser is a recursive descent parser for dice expressions
+type parser struct {
+	expr string
+	pos  int
+}
+
+// parseOpposed handles an opposed roll, which can only be the whole expression
+func (p *parser) parseOpposed() (exprNode, error) {
+	left, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	leftEnd := p.pos
+
+	if !p.consumeKeyword("vs") {
+		return left, nil
+	}
+	rightStart := p.pos
+
+	right, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return &opposedNode{
+		left:      left,
+		right:     right,
+		leftText:  strings.TrimSpace(p.expr[:leftEnd]),
+		rightText: strings.TrimSpace(p.expr[rightStart:p.pos]),
+	}, nil
+}
+
+// parseOr handles "or", which binds looser than "and"
+func (p *parser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.consumeKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "or", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd handles "and"
+func (p *parser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.consumeKeyword("and") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "and", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// comparisonOps are the comparison operators, two-character ones first so >= isn't read as >
+var comparisonOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// parseComparison handles a single comparison, so 1 < 2 < 3 must be written with "and".
+// Dice count successes only when their target is marked with s, as in 8d10s>=7.
+func (p *parser) parseComparison() (exprNode, error) {
+	left, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipWhitespace()
+	for _, op := range comparisonOps {
+		if strings.HasPrefix(p.expr[p.pos:], op) {
+			p.pos += len(op)
+			right, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			return &comparisonNode{op: op, left: left, right: right}, nil
+		}
+	}
+
+	return left, nil
+}
+
+// parseExpression handles addition and subtraction
+func (p *parser) parseExpression() (exprNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipWhitespace()
+		if p.pos >= len(p.expr) {
+			break
+		}
+
+		op := p.expr[p.pos]
+		if op != '+' && op != '-' {
+			break
+		}
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseTerm handles multiplication, division and implicit multiplication
+func (p *parser) parseTerm() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipWhitespace()
+		if p.pos >= len(p.expr) {
+			break
+		}
+
+		c := p.expr[p.pos]
+		if c == '*' || c == '/' {
+			p.pos++
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			left = &binaryNode{op: c, left: left, right: right}
+		} else if startsFactor(c) || funcPattern.MatchString(p.expr[p.pos:]) || ifPattern.MatchString(p.expr[p.pos:]) {
+			// Implicit multiplication for things that look like factors, e.g. 2(d6)
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			left = &binaryNode{op: '*', left: left, right: right}
+		} else {
+			break
+		}
+	}
+
+	return left, nil
+}
+
+// parseUnary handles unary minus, which binds looser than exponentiation so -2^2 is -4
+func (p *parser) parseUnary() (exprNode, error) {
+	p.skipWhitespace()
+	if p.pos < len(p.expr) && p.expr[p.pos] == '-' {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: '-', operand: operand}, nil
+	}
+	return p.parsePower()
+}
+
+// parsePower handles exponentiation, which is right-associative so 2^3^2 is 2^9
+func (p *parser) parsePower() (exprNode, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipWhitespace()
+	if p.pos < len(p.expr) && p.expr[p.pos] == '^' {
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: '^', left: base, right: exponent}, nil
+	}
+
+	return base, nil
+}
+
+// parsePrimary handles parentheses, dice and numbers
+func (p *parser) parsePrimary() (exprNode, error) {
+	p.skipWhitespace()
+	if p.pos >= len(p.expr) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	// Parentheses
+	if p.expr[p.pos] == '(' {
+		p.pos++
+		return p.parseParenthesized()
+	}
+
+	remaining := p.expr[p.pos:]
+
+	// Conditionals
+	if m := ifPattern.FindString(remaining); m != "" {
+		p.pos += len(m)
+		return p.parseIf()
+	}
+
+	// Rounding functions
+	if m := funcPattern.FindStringSubmatch(remaining); m != nil {
+		p.pos += len(m[0])
+		arg, err := p.parseParenthesized()
+		if err != nil {
+			return nil, err
+		}
+		return &funcNode{name: m[1], arg: arg}, nil
+	}
+
+	// Dice must be tried before numbers so the count in 2d6 isn't read as a number
+	if m := dicePattern.FindStringSubmatch(remaining); m != nil {
+		start := p.pos
+		p.pos += len(m[0])
+		return p.parseDice(m, start)
+	}
+
+	if loc := numberPattern.FindStringIndex(remaining); loc != nil {
+		token := remaining[loc[0]:loc[1]]
+		p.pos += loc[1]
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", token)
+		}
+		return &numberNode{value: value}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected character at position %d: '%c'", p.pos, p.expr[p.pos])
+}
+
+// parseIf parses the arguments of a conditional, after its opening parenthesis
+func (p *parser) parseIf() (*ifNode, error) {
+	var args []exprNode
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		p.skipWhitespace()
+		if p.pos < len(p.expr) && p.expr[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos >= len(p.expr) || p.expr[p.pos] != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		break
+	}
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, fmt.Errorf("if takes a condition, a result, an otherwise result and an optional critical result, got %d arguments", len(args))
+	}
+
+	node := &ifNode{cond: args[0], then: args[1], otherwise: args[2]}
+	if len(args) == 4 {
+		node.critical = args[3]
+		node.natural = firstDice(node.cond)
+		switch {
+		case node.natural == nil:
+			return nil, fmt.Errorf("a critical result needs the condition to start with dice such as d20")
+		case node.natural.explode != nil:
+			return nil, fmt.Errorf("a critical result can't be told from the condition's first dice when they explode")
+		case node.natural.successes != nil:
+			return nil, fmt.Errorf("a critical result can't be told from the condition's first dice when they count successes")
+		}
+	}
+	return node, nil
+}
+
+// parseParenthesized parses the rest of a parenthesized expression, after its opening parenthesis
+func (p *parser) parseParenthesized() (exprNode, error) {
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipWhitespace()
+	if p.pos >= len(p.expr) || p.expr[p.pos] != ')' {
+		return nil, fmt.Errorf("missing closing parenthesis")
+	}
+	p.pos++
+	return node, nil
+}
+
+// parseDice builds a diceNode from the submatches of dicePattern and any modifiers that follow it
+func (p *parser) parseDice(m []string, start int) (*diceNode, error) {
+	prefixModifier, countStr, sidesStr := m[1], m[2], m[3]
+
+	// Determine count (default is 1)
+	count := 1
+	if countStr != "" {
+		var err error
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid dice count: %s", countStr)
+		}
+	}
+
+	faces, err := parseFaces(sidesStr)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &diceNode{count: count, die: sidesStr, faces: faces, start: start}
+
+	// Modifiers may follow the dice in any order, each at most once
+	suffixModifier := ""
+	for {
+		remaining := p.expr[p.pos:]
+		if mm := rerollPattern.FindStringSubmatch(remaining); mm != nil {
+			if node.reroll != nil {
+				return nil, fmt.Errorf("duplicate reroll modifier: %s", mm[0])
+			}
+			node.reroll, err = newReroll(mm, node)
+			if err != nil {
+				return nil, err
+			}
+			p.pos += len(mm[0])
+		} else if mm := explodePattern.FindStringSubmatch(remaining); mm != nil && !strings.HasPrefix(remaining, "!=") {
+			if node.explode != nil {
+				return nil, fmt.Errorf("duplicate explosion modifier: %s", mm[0])
+			}
+			node.explode, err = newExplosion(mm, node)
+			if err != nil {
+				return nil, err
+			}
+			p.pos += len(mm[0])
+		} else if mm := successPattern.FindStringSubmatch(remaining); mm != nil {
+			if node.successes != nil {
+				return nil, fmt.Errorf("duplicate success target: %s", mm[0])
+			}
+			compare := byte('=')
+			if mm[1] != "" {
+				compare = mm[1][0]
+			}
+			target, _ := strconv.Atoi(mm[2])
+			node.successes = &successCount{success: comparePoint{compare: compare, target: target}}
+			p.pos += len(mm[0])
+		} else if mm := failurePattern.FindStringSubmatch(remaining); mm != nil {
+			if node.successes == nil {
+				return nil, fmt.Errorf("failure target %s must follow a success target such as s>=7", mm[0])
+			}
+			if node.successes.failures {
+				return nil, fmt.Errorf("duplicate failure target: %s", mm[0])
+			}
+			compare := byte('=')
+			if mm[1] != "" {
+				compare = mm[1][0]
+			}
+			target, _ := strconv.Atoi(mm[2])
+			node.successes.failure = comparePoint{compare: compare, target: target}
+			node.successes.failures = true
+			p.pos += len(mm[0])
+		} else if mm := keepPattern.FindString(remaining); mm != "" {
+			if suffixModifier != "" {
+				return nil, fmt.Errorf("duplicate keep/drop modifier: %s", mm)
+			}
+			suffixModifier = mm
+			p.pos += len(mm)
+		} else {
+			break
+		}
+	}
+	node.end = p.pos
+
+	if node.successes != nil {
+		if !node.canMeet(node.successes.success) {
+			return nil, fmt.Errorf("no roll of %s can meet its success target", p.expr[start:node.end])
+		}
+		if node.successes.failures && !node.canMeet(node.successes.failure) {
+			return nil, fmt.Errorf("no roll of %s can meet its failure target", p.expr[start:node.end])
+		}
+	}
+
+	// Determine which modifier to use (priority: suffix > prefix)
+	modifier := suffixModifier
+	if modifier == "" {
+		modifier = prefixModifier
+	}
+
+	node.selection, err = newDiceSelection(modifier, count)
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// canMeet reports whether a die of the term can count towards a compare point: whether any
+// face it can show matches, or for compounding dice whether their total can grow to a high target
+func (n *diceNode) canMeet(c comparePoint) bool {
+	if c.compare == '>' && n.explode != nil && n.explode.kind == "!!" {
+		return true
+	}
+	for _, face := range n.faces {
+		// Faces rerolled until they stop matching are never shown
+		if n.reroll != nil && !n.reroll.once && !n.reroll.keepBest && n.reroll.matches(face) {
+			continue
+		}
+		if c.matches(face) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxDieSides bounds a numbered die, since every face is listed when it is parsed
+const maxDieSides = 1_000_000
+
+// maxDieFace bounds the faces of a face list, so that sums of many dice can't overflow
+const maxDieFace = 1_000_000_000
+
+// parseFaces returns the face values of the die written after the d
+func parseFaces(die string) ([]int, error) {
+	switch {
+	case die == "x":
+		return nil, fmt.Errorf("dx requires a number (e.g., d20). Please use a specific die like d20 or d100")
+	case die == "F":
+		// Fudge/Fate dice
+		return []int{-1, 0, 1}, nil
+	case strings.HasPrefix(die, "{"):
+		var faces []int
+		for _, field := range strings.Split(die[1:len(die)-1], ",") {
+			face, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, fmt.Errorf("invalid die face %q in d%s", strings.TrimSpace(field), die)
+			}
+			if face < -maxDieFace || face > maxDieFace {
+				return nil, fmt.Errorf("die faces must be between %d and %d", -maxDieFace, maxDieFace)
+			}
+			faces = append(faces, face)
+		}
+		return faces, nil
+	}
+
+	sides, err := strconv.Atoi(die)
+	if err != nil || sides <= 0 {
+		return nil, fmt.Errorf("invalid dice sides: %s", die)
+	}
+	if sides > maxDieSides {
+		return nil, fmt.Errorf("dice may have at most %d sides", maxDieSides)
+	}
+	faces := make([]int, sides)
+	for i := range faces {
+		faces[i] = i + 1
+	}
+	return faces, nil
+}
+
+// newReroll builds a reroll from the submatches of rerollPattern
+func newReroll(m []string, n *diceNode) (*reroll, error) {
+	target, err := strconv.Atoi(m[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid reroll target: %s", m[3])
+	}
+
+	r := &reroll{
+		comparePoint: comparePoint{compare: m[2][0], target: target},
+		once:         m[1] != "",
+		keepBest:     m[1] == "k",
+	}
+
+	// Rerolling until no match needs at least one face that doesn't match
+	if !r.once {
+		stops := false
+		for _, face := range n.faces {
+			if !r.matches(face) {
+				stops = true
+				break
+			}
+		}
+		if !stops {
+			return nil, fmt.Errorf("d%s would reroll forever on %s", n.die, m[0])
+		}
+	}
+
+	return r, nil
+}
+
+// newExplosion builds an explosion from the submatches of explodePattern
+func newExplosion(m []string, n *diceNode) (*explosion, error) {
+	// Explode on the highest face by default
+	threshold := slices.Max(n.faces)
+	if m[3] != "" {
+		var err error
+		threshold, err = strconv.Atoi(m[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid explosion threshold: %s", m[3])
+		}
+	}
+
+	// A threshold at or below the lowest face would make every roll explode forever
+	if threshold <= slices.Min(n.faces) {
+		return nil, fmt.Errorf("d%s cannot explode on %d or higher", n.die, threshold)
+	}
+
+	return &explosion{kind: m[1], threshold: threshold}, nil
+}
+
+// newDiceSelection converts a keep/drop modifier into the dice it keeps from a pool of count dice
+func newDiceSelection(modifier string, count int) (*diceSelection, error) {
+	switch modifier {
+	case "":
+		return nil, nil
+	case "H":
+		return &diceSelection{highest: true, keep: 1}, nil
+	case "L":
+		return &diceSelection{highest: false, keep: 1}, nil
+	}
+
+	n, err := strconv.Atoi(modifier[2:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid dice modifier: %s", modifier)
+	}
+
+	switch modifier[:2] {
+	case "kh", "kl":
+		if n <= 0 || n > count {
+			return nil, fmt.Errorf("cannot keep %d of %d dice", n, count)
+		}
+		return &diceSelection{highest: modifier[1] == 'h', keep: n}, nil
+	default: // "dh", "dl"
+		if n < 0 || n >= count {
+			return nil, fmt.Errorf("cannot drop %d of %d dice", n, count)
+		}
+		// Dropping the highest keeps the lowest and vice versa
+		return &diceSelection{highest: modifier[1] == 'l', keep: count - n}, nil
+	}
+}
+
+// firstDice returns the first dice term rolled when evaluating node, or nil if it rolls none
+func firstDice(node exprNode) *diceNode {
+	switch n := node.(type) {
+	case *diceNode:
+		return n
+	case *unaryNode:
+		return firstDice(n.operand)
+	case *funcNode:
+		return firstDice(n.arg)
+	case *binaryNode:
+		return cmp.Or(firstDice(n.left), firstDice(n.right))
+	case *comparisonNode:
+		return cmp.Or(firstDice(n.left), firstDice(n.right))
+	case *logicalNode:
+		return cmp.Or(firstDice(n.left), firstDice(n.right))
+	case *ifNode:
+		return firstDice(n.cond)
+	}
+	return nil
+}
+
+// diceTerms returns every dice term of an expression, including those of branches that
+// may not be rolled
+func diceTerms(node exprNode) []*diceNode {
+	switch n := node.(type) {
+	case *diceNode:
+		return []*diceNode{n}
+	case *unaryNode:
+		return diceTerms(n.operand)
+	case *funcNode:
+		return diceTerms(n.arg)
+	case *binaryNode:
+		return append(diceTerms(n.left), diceTerms(n.right)...)
+	case *comparisonNode:
+		return append(diceTerms(n.left), diceTerms(n.right)...)
+	case *logicalNode:
+		return append(diceTerms(n.left), diceTerms(n.right)...)
+	case *opposedNode:
+		return append(diceTerms(n.left), diceTerms(n.right)...)
+	case *ifNode:
+		terms := append(diceTerms(n.cond), diceTerms(n.then)...)
+		terms = append(terms, diceTerms(n.otherwise)...)
+		return append(terms, diceTerms(n.critical)...)
+	}
+	return nil
+}
+
+// highest returns the highest total a dice term can roll without exploding
+func (n *diceNode) highest() int {
+	kept := n.count
+	if n.selection != nil {
+		kept = n.selection.keep
+	}
+	return kept * slices.Max(n.faces)
+}
+
+// startsFactor reports whether c can begin an implicitly multiplied factor
+func startsFactor(c byte) bool {
+	return c == '(' || c == '.' || c == 'd' || c == 'H' || c == 'L' || isDigit(c)
+}
+
+// consumeKeyword skips past keyword if it comes next as a whole word, reporting whether it did
+func (p *parser) consumeKeyword(keyword string) bool {
+	p.skipWhitespace()
+	rest := p.expr[p.pos:]
+	if !strings.HasPrefix(rest, keyword) {
+		return false
+	}
+	if len(rest) > len(keyword) && isLetter(rest[len(keyword)]) {
+		return false
+	}
+	p.pos += len(keyword)
+	return true
+}
+
+// skipWhitespace skips over whitespace characters
+func (p *parser) skipWhitespace() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t' || p.expr[p.pos] == '\n' || p.expr[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// isDigit checks if a character is a digit
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// isLetter checks if a character is an ASCII letter
+func isLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
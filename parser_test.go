@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		expression string
+		count      int
+		faces      int
+		keep       int    // 0 when every die is summed
+		highest    bool   // which dice are kept
+		explode    string // the explosion's kind, or "" if the dice don't explode
+		reroll     string // the reroll's compare and target, or ""
+		successes  bool
+	}{
+		{"d20", 1, 20, 0, false, "", "", false},
+		{"4d6kh3", 4, 6, 3, true, "", "", false},
+		{"4d6dl1", 4, 6, 3, true, "", "", false},
+		{"2d20L", 2, 20, 1, false, "", "", false},
+		{"H2d20", 2, 20, 1, true, "", "", false},
+		{"4dF", 4, 3, 0, false, "", "", false},
+		{"3d{1,1,2,3,5,8}", 3, 6, 0, false, "", "", false},
+		{"d6!", 1, 6, 0, false, "!", "", false},
+		{"5d6!!", 5, 6, 0, false, "!!", "", false},
+		{"d6!p>5", 1, 6, 0, false, "!p", "", false},
+		{"2d6ro<2", 2, 6, 0, false, "", "<2", false},
+		{"d20rk<10", 1, 20, 0, false, "", "<10", false},
+		{"10d10s>=8", 10, 10, 0, false, "", "", true},
+		{"8d10s>=7f1", 8, 10, 0, false, "", "", true},
+		{"6d6s6", 6, 6, 0, false, "", "", true},
+		{"4d6r=1kh3", 4, 6, 3, true, "", "=1", false},
+	}
+	for _, tt := range tests {
+		root, err := parseDiceExpression(tt.expression)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expression, err)
+			continue
+		}
+		n, ok := root.(*diceNode)
+		if !ok {
+			t.Errorf("%s: parsed as %T, want dice", tt.expression, root)
+			continue
+		}
+		if n.count != tt.count || len(n.faces) != tt.faces {
+			t.Errorf("%s: %d dice of %d faces, want %d of %d", tt.expression, n.count, len(n.faces), tt.count, tt.faces)
+		}
+		keep, highest := 0, false
+		if n.selection != nil {
+			keep, highest = n.selection.keep, n.selection.highest
+		}
+		if keep != tt.keep || highest != tt.highest {
+			t.Errorf("%s: keeps %d (highest %v), want %d (highest %v)", tt.expression, keep, highest, tt.keep, tt.highest)
+		}
+		explode := ""
+		if n.explode != nil {
+			explode = n.explode.kind
+		}
+		reroll := ""
+		if n.reroll != nil {
+			reroll = fmt.Sprintf("%c%d", n.reroll.compare, n.reroll.target)
+		}
+		if explode != tt.explode || reroll != tt.reroll || (n.successes != nil) != tt.successes {
+			t.Errorf("%s: explode %q, reroll %q, successes %v", tt.expression, explode, reroll, n.successes != nil)
+		}
+	}
+}
+
+func TestParseComparisons(t *testing.T) {
+	// >= and <= right after dice compare the total unless the target is marked with s
+	tests := []struct {
+		expression string
+		condition  bool
+	}{
+		{"2d6>=7", true},
+		{"2d6 >= 7", true},
+		{"d20+d6!>=15", true},
+		{"d6!=3", true},
+		{"(d20>=10) and (d6>=4)", true},
+		{"8d10s>=7", false},
+		{"d6!", false},
+	}
+	for _, tt := range tests {
+		root, err := parseDiceExpression(tt.expression)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expression, err)
+			continue
+		}
+		if got := isCondition(root); got != tt.condition {
+			t.Errorf("%s: condition %v, want %v", tt.expression, got, tt.condition)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string // part of the error message
+	}{
+		{"", "empty expression"},
+		{"2d6+", "unexpected end of expression"},
+		{"(d6", "missing closing parenthesis"},
+		{"dx", "dx requires a number"},
+		{"0d6", "invalid dice count"},
+		{"d0", "invalid dice sides"},
+		{"d{1,a}", "invalid die face"},
+		{"4d6kh5", "cannot keep 5 of 4 dice"},
+		{"d6r<6", "would reroll forever"},
+		{"d6!>1", "cannot explode on 1 or higher"},
+		{"d6!!!", "duplicate explosion modifier"},
+		{"d6f1", "must follow a success target"},
+		{"d6s7", "no roll of d6s7 can meet its success target"},
+		{"d6r<2s<=2", "can meet its success target"},
+		{"d6s>=6f0", "can meet its failure target"},
+		{"if(d20>=15,1)", "got 2 arguments"},
+		{"if(3>=2,1,0,2)", "needs the condition to start with dice"},
+		{"if(d6!>=3,1,0,2)", "when they explode"},
+		{"if(d6s>=3>=1,1,0,2)", "when they count successes"},
+	}
+	for _, tt := range tests {
+		_, err := parseDiceExpression(tt.expression)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want it to contain %q", tt.expression, err, tt.want)
+		}
+	}
+}
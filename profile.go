@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// characterProfile holds a character's variables, such as STR=4 or PROF=3. They are
+// substituted into an expression before it is rolled or graphed, so d20+STR+PROF
+// rolls as d20+4+3.
+type characterProfile struct {
+	name      string
+	variables map[string]float64
+}
+
+// profileFile is the file in the storage root that holds the character profile, shared by
+// the window and the REPL
+const profileFile = "profile.json"
+
+// savedProfile is a character profile as it is stored
+type savedProfile struct {
+	Name      string             `json:"name"`
+	Variables map[string]float64 `json:"variables"`
+}
+
+// loadProfile reads the saved character profile, which has no variables until one is set.
+// If it can't be read, it returns an empty profile with the error.
+func loadProfile() (*characterProfile, error) {
+	var saved savedProfile
+	if err := loadJSON(profileFile, &saved); err != nil {
+		return &characterProfile{variables: map[string]float64{}}, fmt.Errorf("loading profile: %w", err)
+	}
+	if saved.Variables == nil {
+		saved.Variables = map[string]float64{}
+	}
+	return &characterProfile{name: saved.Name, variables: saved.Variables}, nil
+}
+
+// changeProfile reads the profile with the storage locked, changes it and saves it, so
+// the window and REPL sessions running at once don't lose each other's variables. It
+// returns the profile as it was saved.
+func changeProfile(change func(profile *characterProfile)) (*characterProfile, error) {
+	unlock, err := lockStorage()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	profile, err := loadProfile()
+	if err != nil {
+		return nil, err
+	}
+	change(profile)
+	if err := saveJSON(profileFile, savedProfile{Name: profile.name, Variables: profile.variables}); err != nil {
+		return nil, fmt.Errorf("saving profile: %w", err)
+	}
+	return profile, nil
+}
+
+var (
+	// Variable names start with a capital letter so they can't be mistaken for
+	// dice, modifiers or keywords, which are all lowercase
+	variableNamePattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9_]*$`)
+	// Words that could be variable names, found anywhere in an expression
+	identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+	// Dice with an H or L prefix, such as H2d20, look like names but aren't
+	prefixedDicePattern = regexp.MustCompile(`^[HL]\d*d(\d+|x|F)`)
+)
+
+// isVariableName reports whether name can be used as a variable
+func isVariableName(name string) bool {
+	return variableNamePattern.MatchString(name) && !prefixedDicePattern.MatchString(name)
+}
+
+// parseVariables reads variable definitions written one per line, such as STR=4
+func parseVariables(text string) (map[string]float64, error) {
+	variables := make(map[string]float64)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		name, valueStr, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected NAME=value, got %q", line)
+		}
+		name, valueStr = strings.TrimSpace(name), strings.TrimSpace(valueStr)
+		if !isVariableName(name) {
+			return nil, fmt.Errorf("invalid variable name %q: names start with a capital letter, e.g. STR", name)
+		}
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", name, valueStr)
+		}
+		variables[name] = value
+	}
+	return variables, nil
+}
+
+// definitions returns the profile's variables one per line, as parseVariables reads them
+func (p *characterProfile) definitions() string {
+	names := make([]string, 0, len(p.variables))
+	for name := range p.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + "=" + strconv.FormatFloat(p.variables[name], 'g', -1, 64)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// resolve substitutes the profile's variables into an expression. It also returns the
+// values it used, such as "STR=4, PROF=3", so the history can show where numbers came from.
+func (p *characterProfile) resolve(expression string) (string, string, error) {
+	var sb strings.Builder
+	var used []string
+	last := 0
+	for _, loc := range identifierPattern.FindAllStringIndex(expression, -1) {
+		name := expression[loc[0]:loc[1]]
+		if !isVariableName(name) {
+			continue
+		}
+		value, ok := p.variables[name]
+		if !ok {
+			return "", "", fmt.Errorf("unknown variable: %s", name)
+		}
+
+		text := strconv.FormatFloat(value, 'g', -1, 64)
+		if needsParentheses(expression, loc[0], loc[1], value) {
+			text = "(" + text + ")"
+		}
+		sb.WriteString(expression[last:loc[0]])
+		sb.WriteString(text)
+		last = loc[1]
+
+		binding := name + "=" + strconv.FormatFloat(value, 'g', -1, 64)
+		if !slices.Contains(used, binding) {
+			used = append(used, binding)
+		}
+	}
+	sb.WriteString(expression[last:])
+
+	return sb.String(), strings.Join(used, ", "), nil
+}
+
+// needsParentheses reports whether a value substituted for expression[start:end] must be
+// bracketed to keep its meaning: a negative value, or one written right against a factor
+// it multiplies, as in 2STR
+func needsParentheses(expression string, start, end int, value float64) bool {
+	if value < 0 {
+		return true
+	}
+	if start > 0 {
+		before := expression[start-1]
+		if isDigit(before) || isLetter(before) || before == '.' || before == ')' {
+			return true
+		}
+	}
+	if end < len(expression) {
+		after := expression[end]
+		if isDigit(after) || isLetter(after) || after == '.' || after == '(' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// histogramWidth is how many characters the longest bar of a terminal histogram takes
+const histogramWidth = 40
+
+// replHelp lists what the REPL understands
+const replHelp = `Enter an expression such as 2d20H+5 to roll it. Rolls are saved to the same history
+as the calculator window, and variables to the same character profile.
+
+  NAME = value            set a profile variable, e.g. STR = 4, then roll d20+STR
+  :vars                   list the variables
+  :stats EXPRESSION       graph the chance of every result
+  :compare A; B; ...      compare up to six expressions
+  :history [N]            list the last N rolls, 10 by default
+  :nonce [TEXT]           set the nonce the players chose for the rolls' seeds, or show
+                          it, the next roll's commitment and the newest roll's hash
+  !N                      roll the equation of roll N again
+  !!                      roll the last equation again
+  :help                   show this help
+  :quit                   leave (or press Ctrl+D)
+
+Use the arrow keys to edit the line and to recall earlier lines.
+`
+
+// repl is an interactive session in the terminal that shares the history store with the window
+type repl struct {
+	in      lineReader
+	out     io.Writer
+	ascii   bool // draw histograms with # instead of Unicode blocks
+	profile *characterProfile
+	ledger  *rollLedger
+}
+
+// runREPL is the repl command: it reads expressions and commands until the input ends
+func runREPL(args []string) int {
+	flags := newFlagSet("repl")
+	ascii := flags.Bool("ascii", false, "draw histograms with plain ASCII")
+	if err := flags.Parse(args); err != nil {
+		return usageError("repl", err)
+	}
+	if flags.NArg() > 0 {
+		return usageError("repl", fmt.Errorf("unexpected argument: %s", flags.Arg(0)))
+	}
+
+	ledger, err := openLedger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dicecalc repl: %v; rolls won't be added to the ledger\n", err)
+	}
+
+	// Lines typed at a terminal can be edited and recalled; piped input is read as it is
+	var in lineReader = &scannerReader{in: bufio.NewScanner(os.Stdin), out: os.Stdout}
+	if isTerminal(int(os.Stdin.Fd())) && isTerminal(int(os.Stdout.Fd())) {
+		editor, err := newLineEditor(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dicecalc repl: %v; lines won't be kept for later sessions\n", err)
+		}
+		in = editor
+	}
+
+	// Variables are shared with the window's character profile
+	profile, err := loadProfile()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dicecalc repl: %v; starting without variables\n", err)
+	}
+
+	r := &repl{
+		in:      in,
+		out:     os.Stdout,
+		ascii:   *ascii,
+		profile: profile,
+		ledger:  ledger,
+	}
+	r.run()
+	return exitOK
+}
+
+// run reads and handles lines until the input ends or the user quits
+func (r *repl) run() {
+	fmt.Fprintln(r.out, "Dice Statistics Calculator. Type :help for help.")
+	for {
+		line, err := r.in.readLine("dice> ")
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(r.out, "error: %v\n", err)
+			}
+			fmt.Fprintln(r.out)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == ":quit" || line == ":q" || line == ":exit" {
+			return
+		}
+		if err := r.handle(line); err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+		}
+	}
+}
+
+// handle runs one line of input
+func (r *repl) handle(line string) error {
+	if line == "" {
+		return nil
+	}
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch {
+	case command == ":help" || command == ":h":
+		fmt.Fprint(r.out, replHelp)
+		return nil
+	case command == ":vars":
+		if len(r.profile.variables) == 0 {
+			fmt.Fprintln(r.out, "no variables; set one with e.g. STR = 4")
+			return nil
+		}
+		fmt.Fprintln(r.out, r.profile.definitions())
+		return nil
+	case command == ":stats":
+		return r.stats(arg)
+	case command == ":compare":
+		return r.compare(arg)
+	case command == ":history":
+		return r.history(arg)
+	case command == ":nonce":
+		return r.nonce(arg)
+	case strings.HasPrefix(command, ":"):
+		return fmt.Errorf("unknown command %s; type :help for help", command)
+	case strings.HasPrefix(line, "!"):
+		return r.repeat(strings.TrimPrefix(line, "!"))
+	}
+
+	if name, value, ok := strings.Cut(line, "="); ok && isVariableName(strings.TrimSpace(name)) {
+		return r.assign(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return r.roll(line)
+}
+
+// assign sets a variable of the character profile and saves it, so the window and later
+// sessions can use it too
+func (r *repl) assign(name, valueStr string) error {
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %s", name, valueStr)
+	}
+	profile, err := changeProfile(func(profile *characterProfile) {
+		profile.variables[name] = value
+	})
+	if err != nil {
+		r.profile.variables[name] = value
+		return fmt.Errorf("%v; %s is set for this session only", err, name)
+	}
+	r.profile = profile
+	return nil
+}
+
+// roll rolls an equation and adds it to the shared history and the ledger
+func (r *repl) roll(equation string) error {
+	calculations, _, err := recordRoll(equation, r.profile, r.ledger)
+	if err != nil {
+		return err
+	}
+	c := calculations[0]
+	fmt.Fprintf(r.out, "%s %s\n", c.diceRolls, c.result)
+	return nil
+}
+
+// repeat rolls again the equation of a roll in the history, by id, or the newest for "!"
+func (r *repl) repeat(which string) error {
+	calculations, _, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if len(calculations) == 0 {
+		return fmt.Errorf("the history is empty")
+	}
+
+	if which == "!" {
+		return r.roll(calculations[0].equation)
+	}
+	id, err := strconv.Atoi(which)
+	if err != nil {
+		return fmt.Errorf("expected !! or !N, where N is a roll number from :history")
+	}
+	for _, c := range calculations {
+		if c.id == id {
+			return r.roll(c.equation)
+		}
+	}
+	return fmt.Errorf("no roll %d in the history", id)
+}
+
+// nonce sets the players' nonce, or prints it with the next roll's commitment and the
+// newest roll's hash for the players to note down
+func (r *repl) nonce(arg string) error {
+	if r.ledger == nil {
+		return fmt.Errorf("the roll ledger couldn't be opened")
+	}
+	if arg != "" {
+		r.ledger.setNonce(arg)
+		return nil
+	}
+	head, err := r.ledger.head()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "nonce: %q\nnext roll's commitment: %s\nnewest roll: %s\n", r.ledger.nonce, r.ledger.commitment(), head)
+	return nil
+}
+
+// history lists the newest rolls, oldest first so the newest is nearest the prompt
+func (r *repl) history(arg string) error {
+	count := 10
+	if arg != "" {
+		var err error
+		if count, err = strconv.Atoi(arg); err != nil || count < 1 {
+			return fmt.Errorf("expected a number of rolls, got %q", arg)
+		}
+	}
+
+	calculations, _, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if len(calculations) > count {
+		calculations = calculations[:count]
+	}
+	for _, c := range chronological(calculations) {
+		fmt.Fprintf(r.out, "%4d  %s  %s %s\n", c.id, c.equation, c.diceRolls, c.result)
+	}
+	return nil
+}
+
+// stats prints the summary statistics of an expression and a histogram of its results
+func (r *repl) stats(equation string) error {
+	if equation == "" {
+		return fmt.Errorf("expected an expression, e.g. :stats 2d6+3")
+	}
+	expression, _, err := r.profile.resolve(equation)
+	if err != nil {
+		return err
+	}
+	stats, err := CalculateDiceStatistics(expression)
+	if err != nil {
+		return err
+	}
+
+	report := newStatisticsReport(expression, stats)
+	writeSummary(r.out, report)
+	return writeHistogram(r.out, report, r.ascii)
+}
+
+// compare prints the average and standard deviation of each expression and the exact
+// chance that each one rolls higher than every other, like the statistics window
+func (r *repl) compare(text string) error {
+	resolved, _, err := r.profile.resolve(text)
+	if err != nil {
+		return err
+	}
+	series, err := compareSeries(strings.ReplaceAll(resolved, ";", "\n"), DefaultStatisticsOptions)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "Expression\tAverage\tStd Dev\t")
+	for _, s := range series {
+		fmt.Fprintf(tw, "P(> %s)\t", s.expression)
+	}
+	fmt.Fprintln(tw)
+	for i, a := range series {
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t", a.expression, a.stats.Average, a.stats.StdDev)
+		for j, b := range series {
+			if i == j {
+				fmt.Fprint(tw, "-\t")
+				continue
+			}
+			chance, _ := ProbabilityGreater(a.stats, b.stats).Float64()
+			fmt.Fprintf(tw, "%s\t", formatChance(chance))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// writeHistogram draws a bar for the chance of each result, scaled so the most likely
+// result fills histogramWidth characters
+func writeHistogram(w io.Writer, report statisticsReport, ascii bool) error {
+	highest := 0.0
+	for _, outcome := range report.Outcomes {
+		highest = math.Max(highest, outcome.Chance)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	for _, outcome := range report.Outcomes {
+		label := formatOutcome(outcome.Value)
+		if report.Condition {
+			label = strconv.FormatBool(outcome.Value != 0)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t %s\n", label, formatChance(outcome.Chance), histogramBar(outcome.Chance/highest, ascii))
+	}
+	return tw.Flush()
+}
+
+// histogramBar draws a bar filling a fraction of histogramWidth. Unicode bars are drawn
+// to an eighth of a character with the partial block characters.
+func histogramBar(fraction float64, ascii bool) string {
+	if ascii {
+		return strings.Repeat("#", int(math.Round(fraction*histogramWidth)))
+	}
+
+	eighths := int(math.Round(fraction * histogramWidth * 8))
+	bar := strings.Repeat("█", eighths/8)
+	if partial := eighths % 8; partial > 0 {
+		bar += string([]rune("▏▎▍▌▋▊▉")[partial-1])
+	}
+	return bar
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+)
+
+// Roller rolls dice expressions with its own source of randomness, so rolls can be
+// replayed from a seed or drawn from the operating system's secure generator
+type Roller struct {
+	mu  sync.Mutex // a rand.Rand isn't safe to share, and a roll's draws must stay in order
+	rng *rand.Rand
+}
+
+// NewRoller returns a Roller that draws from src
+func NewRoller(src rand.Source) *Roller {
+	return &Roller{rng: rand.New(src)}
+}
+
+// NewSeededRoller returns a Roller that always makes the same rolls for the same seed.
+// The rolls come from ChaCha8, a cryptographically strong generator, so they can't be
+// predicted without the seed.
+func NewSeededRoller(seed uint64) *Roller {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	return NewRoller(rand.NewChaCha8(key))
+}
+
+// NewCryptoRoller returns a Roller that draws every roll from crypto/rand, for players
+// who don't trust a pseudorandom generator
+func NewCryptoRoller() *Roller {
+	return NewRoller(cryptoSource{})
+}
+
+// defaultRoller rolls for CalculateDice, seeded randomly when the program starts
+var defaultRoller = NewRoller(rand.NewChaCha8(randomSeed()))
+
+// Roll parses a dice expression and rolls it, returning the result as a tree
+func (r *Roller) Roll(expression string) (*RollResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return rollWith(expression, r.rng)
+}
+
+// NewSeed draws a seed for NewSeededRoller, so a roll made from it can be replayed
+func (r *Roller) NewSeed() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Uint64()
+}
+
+// cryptoSource is a rand.Source reading from crypto/rand
+type cryptoSource struct{}
+
+func (cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	crand.Read(b[:]) // Never fails, per crypto/rand
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// randomSeed returns a seed for ChaCha8 from crypto/rand
+func randomSeed() [32]byte {
+	var seed [32]byte
+	crand.Read(seed[:])
+	return seed
+}
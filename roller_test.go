@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// The ledger replays rolls from their seeds, so a seed must keep rolling the same dice
+func TestSeededRolls(t *testing.T) {
+	tests := []struct {
+		expression string
+		seed       uint64
+		value      float64
+		dice       []dieRoll // of the first dice term
+	}{
+		{"4d6kh3", 42, 14, []dieRoll{
+			{Rolls: []int{6}, Value: 6, Score: 6, Kept: true},
+			{Rolls: []int{2}, Value: 2, Score: 2, Kept: true},
+			{Rolls: []int{2}, Value: 2, Score: 2},
+			{Rolls: []int{6}, Value: 6, Score: 6, Kept: true},
+		}},
+		{"d20", 7, 1, []dieRoll{
+			{Rolls: []int{1}, Value: 1, Score: 1, Kept: true},
+		}},
+		{"2d6ro<2+3", 1, 11, []dieRoll{
+			{Rolls: []int{2}, Rerolled: []int{1}, Value: 2, Score: 2, Kept: true},
+			{Rolls: []int{6}, Rerolled: []int{2}, Value: 6, Score: 6, Kept: true},
+		}},
+		{"d6!", 42, 8, []dieRoll{
+			{Rolls: []int{6, 2}, Value: 8, Score: 8, Kept: true},
+		}},
+		{"8d10s>=7f1", 42, 3, []dieRoll{
+			{Rolls: []int{9}, Value: 9, Score: 1, Kept: true},
+			{Rolls: []int{3}, Value: 3, Score: 0, Kept: true},
+			{Rolls: []int{3}, Value: 3, Score: 0, Kept: true},
+			{Rolls: []int{10}, Value: 10, Score: 1, Kept: true},
+			{Rolls: []int{8}, Value: 8, Score: 1, Kept: true},
+			{Rolls: []int{1}, Value: 1, Score: -1, Kept: true},
+			{Rolls: []int{4}, Value: 4, Score: 0, Kept: true},
+			{Rolls: []int{9}, Value: 9, Score: 1, Kept: true},
+		}},
+	}
+	for _, tt := range tests {
+		result, err := NewSeededRoller(tt.seed).Roll(tt.expression)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expression, err)
+		}
+		if result.Value != tt.value {
+			t.Errorf("%s with seed %d: rolled %v, want %v", tt.expression, tt.seed, result.Value, tt.value)
+		}
+		if got := result.Terms()[0].Dice; !reflect.DeepEqual(got, tt.dice) {
+			t.Errorf("%s with seed %d: rolled %+v, want %+v", tt.expression, tt.seed, got, tt.dice)
+		}
+	}
+}
+
+func TestSeededRollerRepeats(t *testing.T) {
+	roller := NewSeededRoller(99)
+	first, _ := roller.Roll("10d20")
+	second, _ := roller.Roll("10d20")
+	again, _ := NewSeededRoller(99).Roll("10d20")
+
+	if first.String() != again.String() {
+		t.Errorf("seed 99 rolled %s, then %s", first, again)
+	}
+	if first.String() == second.String() {
+		t.Errorf("a roller rolled %s twice running", first)
+	}
+}
+
+func TestRollsFollowModifiers(t *testing.T) {
+	tests := []struct {
+		expression string
+		check      func(dice []dieRoll) bool
+	}{
+		{"4d6kh3", func(dice []dieRoll) bool {
+			// The dropped die is one of the lowest
+			kept, lowest := 0, dice[0].Value
+			for _, die := range dice {
+				lowest = min(lowest, die.Value)
+				if die.Kept {
+					kept++
+				}
+			}
+			return kept == 3 && slices.ContainsFunc(dice, func(die dieRoll) bool { return !die.Kept && die.Value == lowest })
+		}},
+		{"2d6ro<2", func(dice []dieRoll) bool {
+			for _, die := range dice {
+				if len(die.Rerolled) > 1 || (len(die.Rerolled) == 1 && die.Rerolled[0] > 2) || (len(die.Rerolled) == 0 && die.Value <= 2) {
+					return false
+				}
+			}
+			return true
+		}},
+		{"3d6r<2", func(dice []dieRoll) bool {
+			return !slices.ContainsFunc(dice, func(die dieRoll) bool { return die.Value <= 2 })
+		}},
+		{"3d6!", func(dice []dieRoll) bool {
+			// Every roll of a chain but the last is a 6
+			for _, die := range dice {
+				last := len(die.Rolls) - 1
+				if die.Rolls[last] == 6 || slices.ContainsFunc(die.Rolls[:last], func(roll int) bool { return roll != 6 }) {
+					return false
+				}
+			}
+			return true
+		}},
+		{"8d10s>=7f1", func(dice []dieRoll) bool {
+			for _, die := range dice {
+				want := 0
+				if die.Value >= 7 {
+					want = 1
+				} else if die.Value == 1 {
+					want = -1
+				}
+				if die.Score != want {
+					return false
+				}
+			}
+			return true
+		}},
+		{"4dF", func(dice []dieRoll) bool {
+			return !slices.ContainsFunc(dice, func(die dieRoll) bool { return die.Value < -1 || die.Value > 1 })
+		}},
+	}
+	for _, tt := range tests {
+		for seed := range uint64(200) {
+			result, err := NewSeededRoller(seed).Roll(tt.expression)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.expression, err)
+			}
+			if !tt.check(result.Terms()[0].Dice) {
+				t.Errorf("%s with seed %d rolled %s", tt.expression, seed, result)
+			}
+		}
+	}
+}
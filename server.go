@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// serverLimits bound what one request may ask of the server. Working out statistics
+// grows much faster with the dice than rolling them, so it has tighter limits.
+type serverLimits struct {
+	expressionLength int // characters in an expression
+	dice             int // dice in an expression, across all of its terms
+	sides            int // sides of any one die
+	statsDice        int
+	statsSides       int
+	statsCost        float64 // poolCost of every dice term of a /stats or /compare request
+	explosionDepth   int     // StatisticsOptions.ExplosionDepth of /stats and /compare
+	outcomes         int     // results listed in a /stats or /compare response, all together
+	requestBytes     int64   // size of a POST body
+}
+
+// defaultServerLimits keep the dice pools of a request to about a second of work.
+// Statistics can still be slow for other reasons, such as multiplying two wide
+// distributions, so they also stop when the request times out.
+var defaultServerLimits = serverLimits{
+	expressionLength: 200,
+	dice:             100,
+	sides:            1000,
+	statsDice:        50,
+	statsSides:       100,
+	statsCost:        10_000_000,
+	explosionDepth:   10,
+	outcomes:         10_000,
+	requestBytes:     4096,
+}
+
+// serverTimeout is how long a request may take before the server gives up on it and
+// cancels the request's context
+const serverTimeout = 10 * time.Second
+
+// runServe is the serve command: it answers roll, stats and compare requests with JSON
+// until it is stopped
+func runServe(args []string) int {
+	flags := newFlagSet("serve")
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return usageError("serve", err)
+	}
+	if flags.NArg() > 0 {
+		return usageError("serve", fmt.Errorf("unexpected argument: %s", flags.Arg(0)))
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           http.TimeoutHandler(newServer(defaultServerLimits), serverTimeout, `{"error":"request took too long"}`),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	fmt.Printf("serving on http://%s\n", *addr)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "dicecalc serve: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// newServer returns the API's handler. Every endpoint takes its expressions either as
+// expression query parameters or as a POST body such as {"expression": "2d20H+5"}.
+func newServer(limits serverLimits) http.Handler {
+	s := &apiServer{limits: limits}
+	mux := http.NewServeMux()
+	for path, handle := range map[string]http.HandlerFunc{
+		"/roll":    s.roll,
+		"/stats":   s.stats,
+		"/compare": s.compare,
+	} {
+		mux.HandleFunc("GET "+path, handle)
+		mux.HandleFunc("POST "+path, handle)
+	}
+	return mux
+}
+
+// apiServer answers the API's requests
+type apiServer struct {
+	limits serverLimits
+}
+
+// apiRequest is the POST body of a request
+type apiRequest struct {
+	Expression  string   `json:"expression"`
+	Expressions []string `json:"expressions"` // for /compare
+	Seed        *uint64  `json:"seed"`        // for /roll
+	Depth       *int     `json:"depth"`       // explosion depth for /stats and /compare
+}
+
+// rollResponse is a roll with the history's rendering of it
+type rollResponse struct {
+	*RollResult
+	Display string `json:"display"` // e.g. (2d20: 3̶, 17)+5
+}
+
+// compareResponse is the statistics of each compared expression, and Greater[i][j], the
+// exact chance that expression i rolls higher than expression j
+type compareResponse struct {
+	Expressions []statisticsReport `json:"expressions"`
+	Greater     [][]*float64       `json:"greater"` // null where an expression meets itself
+}
+
+// roll handles /roll: it rolls one expression, from seed if one is given
+func (s *apiServer) roll(w http.ResponseWriter, r *http.Request) {
+	req, err := s.readRequest(w, r)
+	if err == nil {
+		err = s.check(req.Expression, s.limits.dice, s.limits.sides)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	roller := defaultRoller
+	if req.Seed != nil {
+		roller = NewSeededRoller(*req.Seed)
+	}
+	result, err := roller.Roll(req.Expression)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rollResponse{RollResult: result, Display: result.String()})
+}
+
+// stats handles /stats: it works out the chance of every result of one expression
+func (s *apiServer) stats(w http.ResponseWriter, r *http.Request) {
+	req, err := s.readRequest(w, r)
+	if err == nil {
+		err = s.check(req.Expression, s.limits.statsDice, s.limits.statsSides)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	options, err := s.options(req)
+	if err == nil {
+		err = s.checkCost([]string{req.Expression}, options)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stats, err := CalculateDiceStatisticsContext(r.Context(), req.Expression, options)
+	if err == nil {
+		err = s.checkOutcomes(len(stats.Results))
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newStatisticsReport(req.Expression, stats))
+}
+
+// compare handles /compare: it works out the statistics of up to six expressions and
+// the chance that each rolls higher than each other
+func (s *apiServer) compare(w http.ResponseWriter, r *http.Request) {
+	req, err := s.readRequest(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Expressions) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("enter at least one expression"))
+		return
+	}
+	if len(req.Expressions) > len(seriesColors) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("cannot compare more than %d expressions", len(seriesColors)))
+		return
+	}
+
+	options, err := s.options(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, expression := range req.Expressions {
+		if err := s.check(expression, s.limits.statsDice, s.limits.statsSides); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s: %v", expression, err))
+			return
+		}
+	}
+	// The expressions are worked out one after another, so their costs add up
+	if err := s.checkCost(req.Expressions, options); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var series []*DiceStatistics
+	var response compareResponse
+	outcomes := 0
+	for _, expression := range req.Expressions {
+		stats, err := CalculateDiceStatisticsContext(r.Context(), expression, options)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s: %v", expression, err))
+			return
+		}
+		outcomes += len(stats.Results)
+		if err := s.checkOutcomes(outcomes); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		series = append(series, stats)
+		response.Expressions = append(response.Expressions, newStatisticsReport(expression, stats))
+	}
+
+	response.Greater = make([][]*float64, len(series))
+	for i, a := range series {
+		response.Greater[i] = make([]*float64, len(series))
+		for j, b := range series {
+			if i != j {
+				chance, _ := ProbabilityGreater(a, b).Float64()
+				response.Greater[i][j] = &chance
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// readRequest reads a request's expressions and seed from its query or its POST body.
+// A single expression is also returned as the only entry of Expressions.
+func (s *apiServer) readRequest(w http.ResponseWriter, r *http.Request) (apiRequest, error) {
+	var req apiRequest
+	if r.Method == http.MethodPost {
+		body := http.MaxBytesReader(w, r.Body, s.limits.requestBytes)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid request body: %v", err)
+		}
+	} else {
+		query := r.URL.Query()
+		req.Expressions = query["expression"]
+		if len(req.Expressions) > 0 {
+			req.Expression = req.Expressions[0]
+		}
+		if seed := query.Get("seed"); seed != "" {
+			value, err := strconv.ParseUint(seed, 10, 64)
+			if err != nil {
+				return req, fmt.Errorf("invalid seed: %s", seed)
+			}
+			req.Seed = &value
+		}
+		if depth := query.Get("depth"); depth != "" {
+			value, err := strconv.Atoi(depth)
+			if err != nil {
+				return req, fmt.Errorf("invalid depth: %s", depth)
+			}
+			req.Depth = &value
+		}
+	}
+
+	if req.Expression != "" && len(req.Expressions) == 0 {
+		req.Expressions = []string{req.Expression}
+	}
+	return req, nil
+}
+
+// options returns the statistics options of a request, with the explosion depth it asks for
+func (s *apiServer) options(req apiRequest) (StatisticsOptions, error) {
+	options := DefaultStatisticsOptions
+	if req.Depth != nil {
+		if *req.Depth < 0 || *req.Depth > s.limits.explosionDepth {
+			return options, fmt.Errorf("depth must be from 0 to %d", s.limits.explosionDepth)
+		}
+		options.ExplosionDepth = *req.Depth
+	}
+	return options, nil
+}
+
+// check rejects an expression that is too long or has too many dice or sides to work on
+func (s *apiServer) check(expression string, maxDice, maxSides int) error {
+	if expression == "" {
+		return errors.New("missing expression")
+	}
+	if len(expression) > s.limits.expressionLength {
+		return fmt.Errorf("expression is longer than %d characters", s.limits.expressionLength)
+	}
+
+	root, err := parseDiceExpression(expression)
+	if err != nil {
+		return err
+	}
+	dice := 0
+	for _, term := range diceTerms(root) {
+		dice += term.count
+		if len(term.faces) > maxSides {
+			return fmt.Errorf("dice may have at most %d sides", maxSides)
+		}
+	}
+	if dice > maxDice {
+		return fmt.Errorf("expression may have at most %d dice", maxDice)
+	}
+	return nil
+}
+
+// checkCost rejects statistics of expressions whose dice pools would take too long to
+// work out, all together. The expressions must already have passed check.
+func (s *apiServer) checkCost(expressions []string, options StatisticsOptions) error {
+	cost := 0.0
+	for _, expression := range expressions {
+		root, err := parseDiceExpression(expression)
+		if err != nil {
+			return err
+		}
+		for _, term := range diceTerms(root) {
+			cost += poolCost(term, options.ExplosionDepth)
+		}
+	}
+	if cost > s.limits.statsCost {
+		return errors.New("too many dice to work out; use fewer dice, fewer sides, a smaller keep or a lower depth")
+	}
+	return nil
+}
+
+// checkOutcomes rejects a response that would list more results than the limit.
+// Sparse face lists can have a great many totals while costing little to work out.
+func (s *apiServer) checkOutcomes(outcomes int) error {
+	if outcomes > s.limits.outcomes {
+		return fmt.Errorf("too many results to list (%d); at most %d can be", outcomes, s.limits.outcomes)
+	}
+	return nil
+}
+
+// poolCost estimates the work of the statistics of a dice term, in steps of roughly a
+// tenth of a microsecond. Adding a die to a sum of dice pairs each total reached so far
+// with each value of the die, so a sum of n dice takes about totals(1) + ... + totals(n)
+// steps per value, or per total alone for plain numbered dice, whose values are equally
+// likely. A keep/drop pool places every number of the remaining dice on each value, so it
+// takes about count²/2 × values × totals(keep) steps.
+func poolCost(term *diceNode, depth int) float64 {
+	count := float64(term.count)
+	values, span := dieValues(term)
+	if term.explode != nil {
+		values *= float64(depth + 1)
+		span *= float64(depth + 1)
+	}
+
+	// totals returns how many totals n dice can reach: each multiset of their values at
+	// most, and no more than their range holds
+	totals := func(n int) float64 {
+		multisets := 1.0
+		for k := 1; k <= n && multisets <= span*float64(n); k++ {
+			multisets = multisets * (values - 1 + float64(k)) / float64(k)
+		}
+		return min(multisets, (span-1)*float64(n)+1)
+	}
+
+	if term.selection != nil {
+		return count * count / 2 * values * totals(term.selection.keep)
+	}
+	steps := 0.0
+	for n := 1; n <= term.count; n++ {
+		steps += totals(n)
+	}
+	uniform := term.explode == nil && (term.reroll == nil || (!term.reroll.once && !term.reroll.keepBest))
+	if uniform && span == values {
+		return steps
+	}
+	return steps * values
+}
+
+// dieValues returns how many distinct values a die of the term has and how many
+// integers their range holds, which are the same for numbered dice
+func dieValues(term *diceNode) (values, span float64) {
+	distinct := map[int]bool{}
+	lowest, highest := term.faces[0], term.faces[0]
+	for _, face := range term.faces {
+		distinct[face] = true
+		lowest, highest = min(lowest, face), max(highest, face)
+	}
+	return float64(len(distinct)), float64(highest) - float64(lowest) + 1
+}
+
+// writeJSON writes v as the response body. Browser sources such as stream overlays may
+// call the API from any page, so every origin is allowed.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// writeError writes an error as {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// serve sends a request to a server with the default limits, decodes its JSON response
+// into v and returns the status code
+func serve(t *testing.T, method, target, body string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	newServer(defaultServerLimits).ServeHTTP(rec, req)
+
+	if v != nil && rec.Code != http.StatusMethodNotAllowed {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// query returns an endpoint's URL with the expression and any other parameters escaped
+func query(path, expression string, params ...string) string {
+	values := url.Values{"expression": {expression}}
+	for i := 0; i+1 < len(params); i += 2 {
+		values.Set(params[i], params[i+1])
+	}
+	return path + "?" + values.Encode()
+}
+
+func TestServerRoll(t *testing.T) {
+	var got rollResponse
+	if code := serve(t, "GET", query("/roll", "2d6+3"), "", &got); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if got.Value < 5 || got.Value > 15 {
+		t.Errorf("2d6+3 rolled %v", got.Value)
+	}
+	if got.Expression != "2d6+3" || !strings.HasPrefix(got.Display, "(2d6: ") {
+		t.Errorf("got expression %q, display %q", got.Expression, got.Display)
+	}
+}
+
+func TestServerRollSeed(t *testing.T) {
+	var first, second, posted rollResponse
+	serve(t, "GET", query("/roll", "4d6kh3", "seed", "7"), "", &first)
+	serve(t, "GET", query("/roll", "4d6kh3", "seed", "7"), "", &second)
+	serve(t, "POST", "/roll", `{"expression": "4d6kh3", "seed": 7}`, &posted)
+
+	if first.Display != second.Display || first.Display != posted.Display {
+		t.Errorf("seed 7 rolled %q, %q and %q", first.Display, second.Display, posted.Display)
+	}
+	if first.Value != posted.Value {
+		t.Errorf("seed 7 rolled %v and %v", first.Value, posted.Value)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	var got statisticsReport
+	if code := serve(t, "GET", query("/stats", "2d6"), "", &got); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if got.Min != 2 || got.Max != 12 || got.Mean != 7 || len(got.Outcomes) != 11 || got.Total != "36" {
+		t.Errorf("2d6: min %v, max %v, mean %v, %d outcomes of %s", got.Min, got.Max, got.Mean, len(got.Outcomes), got.Total)
+	}
+
+	// With no extra rolls, an exploding d6 is just a d6
+	serve(t, "POST", "/stats", `{"expression": "d6!", "depth": 0}`, &got)
+	if got.Max != 6 || !got.Approximate || math.Abs(got.TruncatedProbability-1.0/6) > 1e-12 {
+		t.Errorf("d6! at depth 0: max %v, approximate %v, truncated %v", got.Max, got.Approximate, got.TruncatedProbability)
+	}
+}
+
+func TestServerCompare(t *testing.T) {
+	var got compareResponse
+	code := serve(t, "POST", "/compare", `{"expressions": ["d20", "d20+1"]}`, &got)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(got.Expressions) != 2 || len(got.Greater) != 2 {
+		t.Fatalf("got %d expressions and %d rows", len(got.Expressions), len(got.Greater))
+	}
+	if got.Greater[0][0] != nil || got.Greater[1][1] != nil {
+		t.Error("an expression was compared with itself")
+	}
+	// d20+1 beats d20 unless the d20 rolls higher than the d20 of d20+1 by two or more
+	if lower, higher := *got.Greater[0][1], *got.Greater[1][0]; lower != 0.4275 || higher != 0.525 {
+		t.Errorf("P(d20 > d20+1) = %v, P(d20+1 > d20) = %v", lower, higher)
+	}
+
+	// Repeated expression parameters are compared too
+	target := "/compare?" + url.Values{"expression": {"2d6", "d12"}}.Encode()
+	if code := serve(t, "GET", target, "", &got); code != http.StatusOK || len(got.Expressions) != 2 {
+		t.Errorf("GET %s: status %d, %d expressions", target, code, len(got.Expressions))
+	}
+}
+
+// sparseDie is a face list whose dice reach a new total with nearly every face they roll
+const sparseDie = "{114139017,170361078,240040410,305883657,351610956,355512575,378479249,497236329,603834390," +
+	"609011111,684361682,691400507,723685183,736343332,748454207,750257551,771862057,999225578}"
+
+func TestServerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   string // part of the error message
+	}{
+		{"missing expression", "GET", "/roll", "", "missing expression"},
+		{"parse error", "GET", query("/roll", "2d6+"), "", ""},
+		{"too long", "GET", query("/roll", strings.Repeat("1+", 100)+"1"), "", "longer than 200 characters"},
+		{"too many dice", "GET", query("/roll", "60d6+41d6"), "", "at most 100 dice"},
+		{"too many sides", "GET", query("/roll", "d1001"), "", "at most 1000 sides"},
+		{"invalid seed", "GET", query("/roll", "d6", "seed", "-1"), "", "invalid seed"},
+		{"bad body", "POST", "/roll", `{"expression": `, "invalid request body"},
+		{"wrong type", "POST", "/roll", `{"expression": 20}`, "invalid request body"},
+		{"body too large", "POST", "/roll", `{"expression": "` + strings.Repeat(" ", 5000) + `d6"}`, "invalid request body"},
+		{"too many stats dice", "GET", query("/stats", "51d6"), "", "at most 50 dice"},
+		{"too many stats sides", "GET", query("/stats", "d101"), "", "at most 100 sides"},
+		{"keep pool too costly", "GET", query("/stats", "50d100kh25"), "", "too many dice to work out"},
+		{"explosions too costly", "GET", query("/stats", "20d100!"), "", "too many dice to work out"},
+		{"sparse dice too costly", "GET", query("/stats", "10d"+sparseDie), "", "too many dice to work out"},
+		{"too many results", "GET", query("/stats", "5d"+sparseDie), "", "too many results to list (26334)"},
+		{"depth too deep", "GET", query("/stats", "d6!", "depth", "11"), "", "depth must be from 0 to 10"},
+		{"invalid depth", "GET", query("/stats", "d6!", "depth", "deep"), "", "invalid depth"},
+		{"division by zero", "GET", query("/stats", "d6/(d2-1)"), "", "division by zero"},
+		{"nothing to compare", "POST", "/compare", `{}`, "at least one expression"},
+		{"too many to compare", "POST", "/compare", `{"expressions": ["d4", "d6", "d8", "d10", "d12", "d20", "d100"]}`, "more than 6"},
+		{"bad compared expression", "POST", "/compare", `{"expressions": ["d6", "d6+"]}`, "d6+: "},
+		{"compare too costly together", "POST", "/compare", `{"expressions": ["15d100kh5", "15d100kh5"]}`, "too many dice to work out"},
+		{"compare too many results together", "GET", "/compare?" + url.Values{"expression": {"4d" + sparseDie, "4d" + sparseDie}}.Encode(), "", "too many results to list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			if code := serve(t, tt.method, tt.target, tt.body, &got); code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d", code, http.StatusBadRequest)
+			}
+			if got["error"] == "" || !strings.Contains(got["error"], tt.want) {
+				t.Errorf("error %q, want it to contain %q", got["error"], tt.want)
+			}
+		})
+	}
+}
+
+func TestServerMethodNotAllowed(t *testing.T) {
+	if code := serve(t, "DELETE", query("/roll", "d6"), "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /roll: status %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestServerStatsStopWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", query("/stats", "2d6"), nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	newServer(defaultServerLimits).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), context.Canceled.Error()) {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
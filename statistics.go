@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math"
-	"regexp"
+	"math/big"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -11,55 +13,162 @@ import (
 
 // DiceStatistics holds the theoretical statistics for a dice roll
 type DiceStatistics struct {
-	MinValue    int
-	MaxValue    int
-	Results     map[int]int     // outcome -> count of ways to achieve it
-	Total       int             // total number of possible outcomes
-	Percentages map[int]float64 // outcome -> percentage
-	Average     float64         // average/mean value
-	MostCommon  int             // most common (median) value
-}
-
-// Distribution represents the frequency distribution of outcomes
-type Distribution map[int]int
-
-// Regex patterns for parsing
-var (
-	diceTokenPattern = regexp.MustCompile(`^([HL])?(\d*)d(\d+)([HL])?`)
-	// Updated numberTokenPattern to include optional decimal part
-	numberTokenPattern = regexp.MustCompile(`^(\d+(\.\d+)?)`)
-)
+	MinValue    float64
+	MaxValue    float64
+	Results     map[float64]*big.Int // outcome -> count of ways to achieve it
+	Total       *big.Int             // total number of possible outcomes
+	Percentages map[float64]float64  // outcome -> percentage
+	Average     float64              // average/mean value
+	Mode        float64              // most common value, the lowest one on a tie
+	Median      float64              // 50th percentile
+	Variance    float64
+	StdDev      float64 // standard deviation
+	IQR         float64 // interquartile range, the 75th percentile less the 25th
+	P5          float64 // 5th percentile
+	P95         float64 // 95th percentile
+	Skewness    float64 // positive when the long tail is above the mean
+	Kurtosis    float64 // excess kurtosis: 0 for a normal distribution, negative for flatter ones
+	Successes   bool    // true when outcomes count successes rather than summing dice
+
+	// Condition is true for a comparison such as d20+7 >= 15, whose outcomes
+	// are 1 when it holds and 0 when it doesn't. Win is the chance that it holds.
+	Condition bool
+
+	// Opposed is true for an opposed roll such as d20+5 vs d20+3, whose outcomes are
+	// the margin the left side wins by. Win, Tie and Lose are the chances of each result.
+	Opposed bool
+	Win     float64
+	Tie     float64
+	Lose    float64
+
+	// Approximate is true when some outcome values could not be represented
+	// exactly, such as fractional powers or exploding dice cut off at the depth cap.
+	// Counts are always exact.
+	Approximate bool
+
+	// TruncatedProbability is the chance that an exploding die would have
+	// rolled past StatisticsOptions.ExplosionDepth. Such dice are counted as
+	// if they had stopped at the cap, so the distribution is approximate when this is non-zero.
+	TruncatedProbability float64
+}
+
+// StatisticsOptions configures how CalculateDiceStatisticsWithOptions builds a distribution
+type StatisticsOptions struct {
+	// ExplosionDepth is how many extra rolls an exploding die may chain before it is cut off
+	ExplosionDepth int
+}
+
+// DefaultStatisticsOptions are the options used by CalculateDiceStatistics
+var DefaultStatisticsOptions = StatisticsOptions{
+	ExplosionDepth: 5,
+}
+
+// maxExplosionDepth is the deepest explosion depth the window and commands accept
+const maxExplosionDepth = 20
+
+// parseExplosionDepth reads an explosion depth typed by the user
+func parseExplosionDepth(text string) (int, error) {
+	depth, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || depth < 0 || depth > maxExplosionDepth {
+		return 0, fmt.Errorf("explosion depth must be a whole number from 0 to %d", maxExplosionDepth)
+	}
+	return depth, nil
+}
+
+// Distribution represents the frequency distribution of outcomes.
+// Outcomes are float64 like the roller's results, so d20/2 and 1.5*d6 have the same values in both.
+// Counts are arbitrary precision since large pools such as 30d20 have more outcomes than fit in an int.
+type Distribution map[float64]*big.Int
+
+// addCount adds count ways of reaching value
+func (d Distribution) addCount(value float64, count *big.Int) {
+	if existing, ok := d[value]; ok {
+		existing.Add(existing, count)
+	} else {
+		d[value] = new(big.Int).Set(count)
+	}
+}
+
+// addProduct adds countA*countB ways of reaching value
+func (d Distribution) addProduct(value float64, countA, countB *big.Int) {
+	d.addCount(value, new(big.Int).Mul(countA, countB))
+}
+
+// total returns the number of outcomes in the distribution
+func (d Distribution) total() *big.Int {
+	total := new(big.Int)
+	for _, count := range d {
+		total.Add(total, count)
+	}
+	return total
+}
+
+// normalized returns the distribution with outcomes that differ only by floating point
+// noise merged. Only the final outcomes are normalized: the operations on the way work
+// on the same values as a roll does, so rounding doesn't build up differently.
+func (d Distribution) normalized() Distribution {
+	res := make(Distribution, len(d))
+	for value, count := range d {
+		res.addCount(normalizeOutcome(value), count)
+	}
+	return res
+}
+
+// normalizeOutcome rounds away floating point noise so that outcomes such as
+// 0.1+0.2 and 0.3 share a bar. Whole numbers are always exact.
+func normalizeOutcome(value float64) float64 {
+	if value == math.Trunc(value) || math.IsInf(value, 0) {
+		return value
+	}
+	normalized, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'g', 15, 64), 64)
+	return normalized
+}
+
+// one is the count of a single outcome
+var one = big.NewInt(1)
 
 // CalculateDiceStatistics calculates the theoretical distribution of possible outcomes for a dice expression
 func CalculateDiceStatistics(expression string) (*DiceStatistics, error) {
+	return CalculateDiceStatisticsWithOptions(expression, DefaultStatisticsOptions)
+}
+
+// CalculateDiceStatisticsWithOptions is CalculateDiceStatistics with explicit options
+func CalculateDiceStatisticsWithOptions(expression string, options StatisticsOptions) (*DiceStatistics, error) {
+	return CalculateDiceStatisticsContext(context.Background(), expression, options)
+}
+
+// CalculateDiceStatisticsContext is CalculateDiceStatisticsWithOptions that gives up with
+// ctx's error once ctx is cancelled, for callers such as the API server that must not
+// keep working on a request that has timed out
+func CalculateDiceStatisticsContext(ctx context.Context, expression string, options StatisticsOptions) (*DiceStatistics, error) {
 	expression = strings.TrimSpace(expression)
 	if expression == "" {
 		return nil, fmt.Errorf("empty expression")
 	}
 
-	parser := &statParser{expr: expression, pos: 0}
-	outcomes, err := parser.parseExpression()
+	root, err := parseDiceExpression(expression)
 	if err != nil {
 		return nil, err
 	}
 
-	parser.skipWhitespace()
-	if parser.pos < len(parser.expr) {
-		return nil, fmt.Errorf("unexpected character at position %d: '%c'", parser.pos, parser.expr[parser.pos])
+	evaluator := &statsEvaluator{ctx: ctx, options: options, untruncated: 1}
+	outcomes, err := evaluator.distribution(root)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(outcomes) == 0 {
 		return nil, fmt.Errorf("no valid outcomes for expression")
 	}
+	outcomes = outcomes.normalized()
 
 	// Find min and max
-	minVal := 0
-	maxVal := 0
+	minVal := 0.0
+	maxVal := 0.0
 	first := true
-	totalCount := 0
+	totalCount := outcomes.total()
 
-	for value, count := range outcomes {
-		totalCount += count
+	for value := range outcomes {
 		if first {
 			minVal = value
 			maxVal = value
@@ -75,9 +184,13 @@ func CalculateDiceStatistics(expression string) (*DiceStatistics, error) {
 	}
 
 	// Calculate percentages
-	percentages := make(map[int]float64)
+	percentages := make(map[float64]float64)
 	for value, count := range outcomes {
-		percentages[value] = (float64(count) / float64(totalCount)) * 100
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ratio, _ := new(big.Rat).SetFrac(count, totalCount).Float64()
+		percentages[value] = ratio * 100
 	}
 
 	stats := &DiceStatistics{
@@ -86,356 +199,479 @@ func CalculateDiceStatistics(expression string) (*DiceStatistics, error) {
 		Results:     outcomes,
 		Total:       totalCount,
 		Percentages: percentages,
+
+		Successes:            evaluator.successes,
+		Approximate:          evaluator.approximate || evaluator.untruncated < 1,
+		TruncatedProbability: 1 - evaluator.untruncated,
+	}
+
+	if err := stats.calculateDescriptiveStatistics(ctx); err != nil {
+		return nil, err
 	}
 
-	// Calculate average and most common value
-	stats.calculateAverageAndMedian()
+	if isCondition(root) {
+		stats.Condition = true
+		stats.Win, _ = stats.ProbabilityAtLeast(1).Float64()
+	}
+	if _, ok := root.(*opposedNode); ok {
+		stats.Opposed = true
+		stats.Win, _ = stats.probability(func(margin float64) bool { return margin > 0 }).Float64()
+		stats.Tie, _ = stats.probability(func(margin float64) bool { return margin == 0 }).Float64()
+		stats.Lose, _ = stats.probability(func(margin float64) bool { return margin < 0 }).Float64()
+	}
 
 	return stats, nil
 }
 
-// statParser implementation
-type statParser struct {
-	expr string
-	pos  int
-}
+// statsEvaluator walks an expression tree, building the distribution of each node
+type statsEvaluator struct {
+	ctx         context.Context // cancelling it stops the evaluation
+	options     StatisticsOptions
+	untruncated float64 // probability that no exploding die reached the depth cap
+	successes   bool    // true once a dice term counting successes has been seen
+	approximate bool    // true once a value could only be approximated
 
-func (p *statParser) skipWhitespace() {
-	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') {
-		p.pos++
-	}
+	// pinned fixes the total of a dice term while a critical hit's condition is worked out
+	pinned map[*diceNode]int
 }
 
-// parseExpression handles addition and subtraction
-func (p *statParser) parseExpression() (Distribution, error) {
-	left, err := p.parseTerm()
-	if err != nil {
+// distribution computes the distribution of an expression tree
+func (e *statsEvaluator) distribution(node exprNode) (Distribution, error) {
+	if err := e.ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	for {
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) {
-			break
+	switch n := node.(type) {
+	case *numberNode:
+		return Distribution{n.value: big.NewInt(1)}, nil
+
+	case *diceNode:
+		if total, ok := e.pinned[n]; ok {
+			return Distribution{float64(total): big.NewInt(1)}, nil
+		}
+		return e.diceDistribution(n)
+
+	case *unaryNode:
+		operand, err := e.distribution(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		return negDist(operand), nil
+
+	case *funcNode:
+		arg, err := e.distribution(n.arg)
+		if err != nil {
+			return nil, err
+		}
+		return mapDist(arg, roundingFuncs[n.name]), nil
+
+	case *comparisonNode:
+		left, err := e.distribution(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.distribution(n.right)
+		if err != nil {
+			return nil, err
 		}
+		return conditionDist(e.ctx, left, right, n.holds)
 
-		if p.expr[p.pos] == '+' {
-			p.pos++
-			right, err := p.parseTerm()
-			if err != nil {
-				return nil, err
+	case *logicalNode:
+		left, err := e.distribution(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.distribution(n.right)
+		if err != nil {
+			return nil, err
+		}
+		return conditionDist(e.ctx, left, right, n.holds)
+
+	case *ifNode:
+		return e.ifDistribution(n)
+
+	case *opposedNode:
+		// The margin of victory is the difference between the two sides
+		left, err := e.distribution(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.distribution(n.right)
+		if err != nil {
+			return nil, err
+		}
+		return pairDist(e.ctx, left, right, func(a, b float64) float64 { return a - b })
+
+	case *binaryNode:
+		left, err := e.distribution(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.distribution(n.right)
+		if err != nil {
+			return nil, err
+		}
+
+		var op func(a, b float64) float64
+		switch n.op {
+		case '+':
+			op = func(a, b float64) float64 { return a + b }
+		case '-':
+			op = func(a, b float64) float64 { return a - b }
+		case '*':
+			op = func(a, b float64) float64 { return a * b }
+		case '/':
+			// The roller fails when it rolls a zero divisor, so any chance of one is an error
+			for valB := range right {
+				if valB == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
 			}
-			left = addDist(left, right)
-		} else if p.expr[p.pos] == '-' {
-			p.pos++
-			right, err := p.parseTerm()
-			if err != nil {
-				return nil, err
+			op = func(a, b float64) float64 { return a / b }
+		case '^':
+			// Fractional exponents generally give irrational results
+			for valB := range right {
+				if valB != math.Trunc(valB) {
+					e.approximate = true
+				}
 			}
-			left = subDist(left, right)
-		} else {
-			break
+			op = math.Pow
+		default:
+			return nil, fmt.Errorf("unknown operator: %c", n.op)
 		}
+		res, err := pairDist(e.ctx, left, right, op)
+		if err != nil {
+			return nil, err
+		}
+		if err := res.finite(); err != nil {
+			return nil, err
+		}
+		return res, nil
 	}
 
-	return left, nil
+	return nil, fmt.Errorf("unknown expression node: %T", node)
 }
 
-// parseTerm handles multiplication, division and implicit multiplication
-func (p *statParser) parseTerm() (Distribution, error) {
-	left, err := p.parsePower()
-	if err != nil {
-		return nil, err
+// finite returns an error if any outcome is infinite or not a number, such as 0^-1
+// or the root of a negative number
+func (d Distribution) finite() error {
+	for value := range d {
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return fmt.Errorf("result is not a finite number: %v", value)
+		}
 	}
+	return nil
+}
 
-	for {
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) {
-			break
-		}
+// Operations on Distributions
 
-		c := p.expr[p.pos]
-		if c == '*' {
-			p.pos++
-			right, err := p.parsePower()
-			if err != nil {
-				return nil, err
-			}
-			left = multDist(left, right)
-		} else if c == '/' {
-			p.pos++
-			right, err := p.parsePower()
-			if err != nil {
-				return nil, err
-			}
-			left = divDist(left, right)
-		} else if c == '(' || (c >= '0' && c <= '9') || c == 'd' || c == 'H' || c == 'L' {
-			// Implicit multiplication for things that look like factors
-			right, err := p.parsePower()
-			if err != nil {
-				return nil, err
-			}
-			left = multDist(left, right)
-		} else {
-			break
+// pairDist returns the distribution of op applied to every pair of outcomes of a and b.
+// Wide distributions have millions of pairs, so it stops with ctx's error if ctx is
+// cancelled part way through.
+func pairDist(ctx context.Context, a, b Distribution, op func(valA, valB float64) float64) (Distribution, error) {
+	res := make(Distribution)
+	for valA, countA := range a {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for valB, countB := range b {
+			res.addProduct(op(valA, valB), countA, countB)
 		}
 	}
+	return res, nil
+}
 
-	return left, nil
+func negDist(a Distribution) Distribution {
+	res := make(Distribution)
+	for val, count := range a {
+		res.addCount(-val, count)
+	}
+	return res
 }
 
-// parsePower handles exponentiation
-func (p *statParser) parsePower() (Distribution, error) {
-	left, err := p.parseFactor()
+// ifDistribution mixes the distributions of a conditional's results, each weighted by
+// the number of rolls of the condition that choose it
+func (e *statsEvaluator) ifDistribution(n *ifNode) (Distribution, error) {
+	cond, err := e.distribution(n.cond)
+	if err != nil {
+		return nil, err
+	}
+	then, err := e.distribution(n.then)
+	if err != nil {
+		return nil, err
+	}
+	otherwise, err := e.distribution(n.otherwise)
 	if err != nil {
 		return nil, err
 	}
 
-	for {
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) {
-			break
-		}
-
-		if p.expr[p.pos] == '^' {
-			p.pos++
-			right, err := p.parseFactor() // Left-associative to match calculator
-			if err != nil {
-				return nil, err
-			}
-			left = powDist(left, right)
+	hits, misses := new(big.Int), new(big.Int)
+	for value, count := range cond {
+		if value != 0 {
+			hits.Add(hits, count)
 		} else {
-			break
+			misses.Add(misses, count)
 		}
 	}
+	if n.critical == nil {
+		return mixDist([]*big.Int{hits, misses}, []Distribution{then, otherwise}), nil
+	}
 
-	return left, nil
-}
-
-// parseFactor handles parentheses, dice, and numbers
-func (p *statParser) parseFactor() (Distribution, error) {
-	p.skipWhitespace()
-	if p.pos >= len(p.expr) {
-		return nil, fmt.Errorf("unexpected end of expression")
+	critical, err := e.distribution(n.critical)
+	if err != nil {
+		return nil, err
 	}
 
-	// Parentheses
-	if p.expr[p.pos] == '(' {
-		p.pos++
-		dist, err := p.parseExpression()
-		if err != nil {
-			return nil, err
-		}
-		p.skipWhitespace()
-		if p.pos >= len(p.expr) || p.expr[p.pos] != ')' {
-			return nil, fmt.Errorf("missing closing parenthesis")
-		}
-		p.pos++
-		return dist, nil
+	// Work out the condition again with the natural dice fixed at their highest total.
+	// Each of those rolls happens once for every way the natural dice can crit, and
+	// crits take the critical result whether or not the condition holds.
+	natural, err := e.diceDistribution(n.natural)
+	if err != nil {
+		return nil, err
+	}
+	critWays := natural[float64(n.natural.highest())]
+	if critWays == nil {
+		critWays = new(big.Int)
 	}
 
-	// Try Dice Pattern
-	remaining := p.expr[p.pos:]
-	if loc := diceTokenPattern.FindStringIndex(remaining); loc != nil {
-		token := remaining[loc[0]:loc[1]]
-		p.pos += loc[1]
-		return parseDiceToken(token)
+	// The condition's exploding dice were already counted towards untruncated the first time,
+	// and an enclosing critical's pinned dice must stay pinned
+	untruncated, pinned := e.untruncated, e.pinned
+	e.pinned = map[*diceNode]int{n.natural: n.natural.highest()}
+	for term, total := range pinned {
+		e.pinned[term] = total
+	}
+	critCond, err := e.distribution(n.cond)
+	e.untruncated, e.pinned = untruncated, pinned
+	if err != nil {
+		return nil, err
 	}
 
-	// Try Number Pattern
-	if loc := numberTokenPattern.FindStringIndex(remaining); loc != nil {
-		token := remaining[loc[0]:loc[1]]
-		p.pos += loc[1]
-		// Parse as float then cast to int (truncate/floor) to handle buttons like "."
-		valFloat, err := strconv.ParseFloat(token, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid number: %s", token)
+	crits := new(big.Int)
+	for value, count := range critCond {
+		ways := new(big.Int).Mul(count, critWays)
+		crits.Add(crits, ways)
+		if value != 0 {
+			hits.Sub(hits, ways)
+		} else {
+			misses.Sub(misses, ways)
 		}
-		return Distribution{int(valFloat): 1}, nil
 	}
-
-	return nil, fmt.Errorf("unexpected character: %c", p.expr[p.pos])
+	return mixDist([]*big.Int{crits, hits, misses}, []Distribution{critical, then, otherwise}), nil
 }
 
-func parseDiceToken(token string) (Distribution, error) {
-	matches := diceTokenPattern.FindStringSubmatch(token)
-
-	if matches != nil {
-		// It is a dice expression
-		prefixModifier := matches[1]
-		countStr := matches[2]
-		sidesStr := matches[3]
-		suffixModifier := matches[4]
+// mixDist returns the mixture of several distributions, the i-th chosen in weights[i] ways.
+// Each outcome is counted once for every combination of the dice in every branch, so the
+// counts stay whole numbers.
+func mixDist(weights []*big.Int, branches []Distribution) Distribution {
+	totals := make([]*big.Int, len(branches))
+	for i, branch := range branches {
+		totals[i] = branch.total()
+	}
 
-		count := 1
-		if countStr != "" {
-			c, err := strconv.Atoi(countStr)
-			if err != nil {
-				return nil, err
+	res := make(Distribution)
+	for i, branch := range branches {
+		scale := new(big.Int).Set(weights[i])
+		for j, total := range totals {
+			if j != i {
+				scale.Mul(scale, total)
 			}
-			count = c
 		}
-
-		sides, err := strconv.Atoi(sidesStr)
-		if err != nil {
-			return nil, err
+		if scale.Sign() == 0 {
+			continue
 		}
-
-		modifier := ""
-		if suffixModifier != "" {
-			modifier = suffixModifier
-		} else if prefixModifier != "" {
-			modifier = prefixModifier
+		for value, count := range branch {
+			res.addProduct(value, count, scale)
 		}
-
-		return getDiceOutcomes(count, sides, modifier), nil
 	}
-
-	return nil, fmt.Errorf("invalid dice term: %s", token)
+	return res
 }
 
-// Operations on Distributions
+// conditionDist returns the Bernoulli distribution of a condition between every pair of outcomes of a and b
+func conditionDist(ctx context.Context, a, b Distribution, holds func(left, right float64) bool) (Distribution, error) {
+	return pairDist(ctx, a, b, func(left, right float64) float64 { return truth(holds(left, right)) })
+}
 
-func addDist(a, b Distribution) Distribution {
+// mapDist applies fn to every outcome of a
+func mapDist(a Distribution, fn func(float64) float64) Distribution {
 	res := make(Distribution)
-	for valA, countA := range a {
-		for valB, countB := range b {
-			res[valA+valB] += countA * countB
-		}
+	for val, count := range a {
+		res.addCount(fn(val), count)
 	}
 	return res
 }
 
-func subDist(a, b Distribution) Distribution {
-	res := make(Distribution)
-	for valA, countA := range a {
-		for valB, countB := range b {
-			res[valA-valB] += countA * countB
+// diceDistribution computes the distribution of a dice term, noting any explosion tail it cuts off
+func (e *statsEvaluator) diceDistribution(n *diceNode) (Distribution, error) {
+	if n.successes != nil {
+		e.successes = true
+	}
+
+	face := faceOutcomes(n.faces, n.reroll)
+	if n.explode == nil {
+		die := make(dieDistribution)
+		for value, count := range face {
+			die.addCount(dieOutcome{value: value, score: rollScore(n, value)}, count)
 		}
+		return e.poolDistribution(n, die)
 	}
-	return res
-}
 
-func multDist(a, b Distribution) Distribution {
-	res := make(Distribution)
-	for valA, countA := range a {
-		for valB, countB := range b {
-			res[valA*valB] += countA * countB
+	depth := e.options.ExplosionDepth
+	if depth < 0 {
+		depth = 0
+	}
+
+	faceTotal := face.total()
+	exploding := new(big.Int)
+	for value, count := range face {
+		if value >= n.explode.threshold {
+			exploding.Add(exploding, count)
 		}
 	}
-	return res
-}
 
-func divDist(a, b Distribution) Distribution {
-	res := make(Distribution)
-	for valA, countA := range a {
-		for valB, countB := range b {
-			if valB == 0 {
-				continue // Division by zero yields no outcome
-			}
-			res[valA/valB] += countA * countB
+	// A die is cut off when its last allowed roll would have exploded again
+	explodeChance, _ := new(big.Rat).SetFrac(exploding, faceTotal).Float64()
+	if explodeChance > 0 {
+		e.untruncated *= math.Pow(1-math.Pow(explodeChance, float64(depth+1)), float64(n.count))
+	}
+
+	die := explodingDieOutcomes(n, face, faceTotal, depth, false)
+	if n.successes != nil && n.explode.kind == "!!" {
+		// Compounding dice count successes on their total rather than on each roll
+		rescored := make(dieDistribution)
+		for outcome, count := range die {
+			rescored.addCount(dieOutcome{value: outcome.value, score: n.successes.score(outcome.value)}, count)
 		}
+		die = rescored
 	}
-	return res
+	return e.poolDistribution(n, die)
 }
 
-func powDist(a, b Distribution) Distribution {
-	res := make(Distribution)
-	for valA, countA := range a {
-		for valB, countB := range b {
-			// Integer exponentiation
-			// Standard behavior for non-negative exponents
-			// Negative exponents with int base result in 0 (unless -1, 1).
-			val := 0
-			if valB >= 0 {
-				val = int(math.Pow(float64(valA), float64(valB)))
-			} else {
-				// Integer division for 1/(a^-b) usually 0
-				val = int(math.Pow(float64(valA), float64(valB)))
-			}
-			res[val] += countA * countB
-		}
+// poolDistribution returns the distribution of a dice term's pool, given the outcomes of one of its dice
+func (e *statsEvaluator) poolDistribution(n *diceNode, die dieDistribution) (Distribution, error) {
+	outcomes, err := getDiceOutcomes(e.ctx, n.count, die, n.selection)
+	if err != nil {
+		return nil, err
 	}
-	return res
+	return outcomes.toDistribution(), nil
 }
 
-// getDiceOutcomes returns a map of all possible outcomes for a dice roll and their frequencies
-func getDiceOutcomes(count int, sides int, modifier string) map[int]int {
-	outcomes := make(map[int]int)
+// dieOutcome is one possible result of a single die in a pool
+type dieOutcome struct {
+	value int // the die's total, used to rank it for keep/drop
+	score int // what the die adds to the result: its value, or its successes
+}
+
+// dieDistribution maps the outcomes of a single die to their frequencies
+type dieDistribution map[dieOutcome]*big.Int
 
-	if modifier == "H" {
-		// Keep only the highest die
-		generateHighestOutcomes(count, sides, []int{}, outcomes)
-	} else if modifier == "L" {
-		// Keep only the lowest die
-		generateLowestOutcomes(count, sides, []int{}, outcomes)
+// addCount adds count ways of reaching outcome
+func (d dieDistribution) addCount(outcome dieOutcome, count *big.Int) {
+	if existing, ok := d[outcome]; ok {
+		existing.Add(existing, count)
 	} else {
-		// Sum all dice
-		generateSumOutcomes(count, sides, []int{}, outcomes)
+		d[outcome] = new(big.Int).Set(count)
 	}
+}
 
-	return outcomes
+// rollScore returns what a single roll adds to the result of a dice term
+func rollScore(n *diceNode, value int) int {
+	if n.successes != nil {
+		return n.successes.score(value)
+	}
+	return value
 }
 
-// generateSumOutcomes recursively generates all sums
-func generateSumOutcomes(remaining int, sides int, current []int, outcomes map[int]int) {
-	if remaining == 0 {
-		sum := 0
-		for _, val := range current {
-			sum += val
+// faceOutcomes returns the outcomes of a single roll of a die with the given faces, after any rerolls
+func faceOutcomes(faces []int, rr *reroll) intDistribution {
+	face := make(intDistribution)
+	if rr == nil {
+		for _, value := range faces {
+			face.addCount(value, one)
 		}
-		outcomes[sum]++
-		return
+		return face
 	}
 
-	for die := 1; die <= sides; die++ {
-		generateSumOutcomes(remaining-1, sides, append(current, die), outcomes)
+	sides := big.NewInt(int64(len(faces)))
+	for _, first := range faces {
+		switch {
+		case !rr.matches(first):
+			// Kept as rolled; for a single reroll weight it over the unused second roll
+			if rr.once {
+				face.addCount(first, sides)
+			} else {
+				face.addCount(first, one)
+			}
+		case rr.keepBest:
+			for _, second := range faces {
+				face.addCount(max(first, second), one)
+			}
+		case rr.once:
+			for _, second := range faces {
+				face.addCount(second, one)
+			}
+		}
+		// Rerolling until no match leaves the non-matching faces equally likely
 	}
+
+	return face
 }
 
-// generateHighestOutcomes recursively generates all highest-die outcomes
-func generateHighestOutcomes(remaining int, sides int, current []int, outcomes map[int]int) {
-	if remaining == 0 {
-		highest := 0
-		for _, val := range current {
-			if val > highest {
-				highest = val
-			}
-		}
-		outcomes[highest]++
-		return
+// explodingDieOutcomes returns the outcomes of a single exploding die that may
+// roll again up to depth more times. face holds the outcomes of one roll and
+// sums to faceTotal, so every chain of depth+1 rolls sums to faceTotal^(depth+1).
+func explodingDieOutcomes(n *diceNode, face intDistribution, faceTotal *big.Int, depth int, extra bool) dieDistribution {
+	outcomes := make(dieDistribution)
+
+	// Weight of the rolls that would have followed a chain that stops here
+	weight := new(big.Int).Exp(faceTotal, big.NewInt(int64(depth)), nil)
+
+	var next dieDistribution
+	if depth > 0 {
+		next = explodingDieOutcomes(n, face, faceTotal, depth-1, true)
 	}
 
-	for die := 1; die <= sides; die++ {
-		generateHighestOutcomes(remaining-1, sides, append(current, die), outcomes)
+	for roll, count := range face {
+		value := roll
+		if extra && n.explode.kind == "!p" {
+			value-- // Penetrating dice lose one from every extra roll
+		}
+		score := rollScore(n, value)
+
+		if roll < n.explode.threshold || next == nil {
+			outcomes.addCount(dieOutcome{value: value, score: score}, new(big.Int).Mul(count, weight))
+			continue
+		}
+		for rest, restCount := range next {
+			outcomes.addCount(dieOutcome{value: value + rest.value, score: score + rest.score}, new(big.Int).Mul(count, restCount))
+		}
 	}
+
+	return outcomes
 }
 
-// generateLowestOutcomes recursively generates all lowest-die outcomes
-func generateLowestOutcomes(remaining int, sides int, current []int, outcomes map[int]int) {
-	if remaining == 0 {
-		lowest := sides + 1
-		for _, val := range current {
-			if val < lowest {
-				lowest = val
-			}
-		}
-		outcomes[lowest]++
-		return
+// getDiceOutcomes returns a map of all possible outcomes for a pool of count dice and their frequencies
+func getDiceOutcomes(ctx context.Context, count int, die dieDistribution, sel *diceSelection) (intDistribution, error) {
+	if sel != nil {
+		// Sum only the kept dice
+		return keepPool(ctx, die, count, sel)
 	}
 
-	for die := 1; die <= sides; die++ {
-		generateLowestOutcomes(remaining-1, sides, append(current, die), outcomes)
+	// Sum all dice; only their scores matter
+	scores := make(intDistribution)
+	for outcome, c := range die {
+		scores.addCount(outcome.score, c)
 	}
+	return sumPool(ctx, scores, count)
 }
 
 // GetSortedOutcomes returns sorted unique outcomes
-func (s *DiceStatistics) GetSortedOutcomes() []int {
-	var outcomes []int
+func (s *DiceStatistics) GetSortedOutcomes() []float64 {
+	var outcomes []float64
 	for value := range s.Results {
 		outcomes = append(outcomes, value)
 	}
-	sort.Ints(outcomes)
+	sort.Float64s(outcomes)
 	return outcomes
 }
 
@@ -450,38 +686,170 @@ func (s *DiceStatistics) GetMaxPercentage() float64 {
 	return maxPercentage
 }
 
-// calculateAverageAndMedian calculates the average and most common value
-func (s *DiceStatistics) calculateAverageAndMedian() {
+// AtLeastPercentages returns the chance, as a percentage, of rolling each outcome or higher
+func (s *DiceStatistics) AtLeastPercentages() map[float64]float64 {
+	return s.cumulativePercentages(true)
+}
+
+// AtMostPercentages returns the chance, as a percentage, of rolling each outcome or lower
+func (s *DiceStatistics) AtMostPercentages() map[float64]float64 {
+	return s.cumulativePercentages(false)
+}
+
+// cumulativePercentages sums the counts of the outcomes from the top or bottom of the range.
+// The running sums are exact, so the percentages don't drift over long ranges.
+func (s *DiceStatistics) cumulativePercentages(atLeast bool) map[float64]float64 {
+	outcomes := s.GetSortedOutcomes()
+	if atLeast {
+		slices.Reverse(outcomes)
+	}
+
+	percentages := make(map[float64]float64, len(outcomes))
+	running := new(big.Int)
+	for _, value := range outcomes {
+		running.Add(running, s.Results[value])
+		ratio, _ := new(big.Rat).SetFrac(running, s.Total).Float64()
+		percentages[value] = ratio * 100
+	}
+	return percentages
+}
+
+// ProbabilityAtLeast returns the exact chance of rolling target or higher, such as meeting a DC
+func (s *DiceStatistics) ProbabilityAtLeast(target float64) *big.Rat {
+	return s.probability(func(value float64) bool { return value >= target })
+}
+
+// ProbabilityAtMost returns the exact chance of rolling target or lower
+func (s *DiceStatistics) ProbabilityAtMost(target float64) *big.Rat {
+	return s.probability(func(value float64) bool { return value <= target })
+}
+
+// probability returns the exact chance of rolling an outcome that matches
+func (s *DiceStatistics) probability(matches func(float64) bool) *big.Rat {
+	count := new(big.Int)
+	for value, c := range s.Results {
+		if matches(value) {
+			count.Add(count, c)
+		}
+	}
+	if s.Total.Sign() == 0 {
+		return new(big.Rat)
+	}
+	return new(big.Rat).SetFrac(count, s.Total)
+}
+
+// ProbabilityGreater returns the exact chance that rolling a comes out higher than rolling b
+func ProbabilityGreater(a, b *DiceStatistics) *big.Rat {
+	// below[i] is how many outcomes of b come in under its i-th lowest value
+	bOutcomes := b.GetSortedOutcomes()
+	below := make([]*big.Int, len(bOutcomes)+1)
+	below[0] = new(big.Int)
+	for i, value := range bOutcomes {
+		below[i+1] = new(big.Int).Add(below[i], b.Results[value])
+	}
+
+	wins := new(big.Int)
+	for value, count := range a.Results {
+		beaten := below[sort.SearchFloat64s(bOutcomes, value)]
+		wins.Add(wins, new(big.Int).Mul(count, beaten))
+	}
+
+	total := new(big.Int).Mul(a.Total, b.Total)
+	if total.Sign() == 0 {
+		return new(big.Rat)
+	}
+	return new(big.Rat).SetFrac(wins, total)
+}
+
+// Percentile returns the lowest outcome that at least p percent of rolls come in at or under
+func (s *DiceStatistics) Percentile(p float64) float64 {
+	outcomes := s.GetSortedOutcomes()
+	if len(outcomes) == 0 {
+		return 0
+	}
+
+	// Compare exact counts so that, say, the median of 1d2 is 1 rather than depending on rounding.
+	// p is read as the decimal it is written as, since 5/100 as a float is a little over 5%.
+	fraction, ok := new(big.Rat).SetString(strconv.FormatFloat(p, 'f', -1, 64))
+	if !ok {
+		return 0
+	}
+	target := fraction.Quo(fraction, big.NewRat(100, 1))
+	target.Mul(target, new(big.Rat).SetInt(s.Total))
+	running := new(big.Int)
+	for _, value := range outcomes {
+		running.Add(running, s.Results[value])
+		if new(big.Rat).SetInt(running).Cmp(target) >= 0 {
+			return value
+		}
+	}
+	return outcomes[len(outcomes)-1]
+}
+
+// calculateDescriptiveStatistics calculates the mean, mode, percentiles and moments of the
+// distribution. Each takes a pass over every outcome, so it stops with ctx's error between
+// them if ctx is cancelled.
+func (s *DiceStatistics) calculateDescriptiveStatistics(ctx context.Context) error {
 	if len(s.Results) == 0 {
-		s.Average = 0
-		s.MostCommon = 0
-		return
+		return nil
 	}
 
-	// Calculate average (mean)
-	sum := 0
-	totalCount := 0
+	// Calculate average (mean) from exact weights, so huge counts don't lose precision
+	sum := new(big.Float)
 	for value, count := range s.Results {
-		sum += value * count
-		totalCount += count
+		weighted := new(big.Float).SetInt(count)
+		sum.Add(sum, weighted.Mul(weighted, big.NewFloat(value)))
 	}
-	s.Average = float64(sum) / float64(totalCount)
+	s.Average, _ = sum.Quo(sum, new(big.Float).SetInt(s.Total)).Float64()
 
 	// Find most common (mode) - the value with highest count
-	maxCount := 0
+	// If there are tied values, choose the smallest one
+	var maxCount *big.Int
 	for value, count := range s.Results {
-		if count > maxCount {
+		cmp := 1
+		if maxCount != nil {
+			cmp = count.Cmp(maxCount)
+		}
+		if cmp > 0 || (cmp == 0 && value < s.Mode) {
 			maxCount = count
-			s.MostCommon = value
+			s.Mode = value
 		}
 	}
 
-	// If there are tied values, choose the smallest one
-	if maxCount > 0 {
-		for value, count := range s.Results {
-			if count == maxCount && value < s.MostCommon {
-				s.MostCommon = value
-			}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Median = s.Percentile(50)
+	s.IQR = s.Percentile(75) - s.Percentile(25)
+	s.P5 = s.Percentile(5)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.P95 = s.Percentile(95)
+
+	// Central moments, weighting each deviation from the mean by its probability
+	var m2, m3, m4 float64
+	for value, count := range s.Results {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		probability, _ := new(big.Rat).SetFrac(count, s.Total).Float64()
+		deviation := value - s.Average
+		m2 += probability * deviation * deviation
+		m3 += probability * deviation * deviation * deviation
+		m4 += probability * deviation * deviation * deviation * deviation
+	}
+	s.Variance = m2
+	s.StdDev = math.Sqrt(m2)
+
+	// A single possible outcome has no spread to describe the shape of
+	if m2 > 0 {
+		s.Skewness = m3 / math.Pow(m2, 1.5)
+		s.Kurtosis = m4/(m2*m2) - 3
+	}
+	// Symmetric distributions come out a rounding error away from zero skew
+	if math.Abs(s.Skewness) < 1e-9 {
+		s.Skewness = 0
 	}
+	return nil
 }
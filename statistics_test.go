@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		expression string
+		p          float64
+		want       float64
+	}{
+		{"d20", 5, 1},
+		{"d20", 5.01, 2},
+		{"d20", 95, 19},
+		{"d100", 5, 5},
+		{"d100", 0.1, 1},
+		{"2d10", 10, 5},
+		{"d2", 50, 1},
+		{"d6", 100, 6},
+	}
+	for _, tt := range tests {
+		stats, err := CalculateDiceStatistics(tt.expression)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expression, err)
+		}
+		if got := stats.Percentile(tt.p); got != tt.want {
+			t.Errorf("%s: P%v = %v, want %v", tt.expression, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestCriticalTruncatedProbability(t *testing.T) {
+	plain, err := CalculateDiceStatistics("d6!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Working out the crits again mustn't count the exploding die twice
+	crit, err := CalculateDiceStatistics("if(d20+d6!>=15,1,0,2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crit.TruncatedProbability != plain.TruncatedProbability {
+		t.Errorf("truncated %v, want %v as for d6!", crit.TruncatedProbability, plain.TruncatedProbability)
+	}
+}
+
+func TestRolledValuesAreOutcomes(t *testing.T) {
+	for _, expression := range []string{"d6d6^d{-2,0}", "d10/10+0.2", "round(d20/3)*1.5", "d6/d6/d6"} {
+		stats, err := CalculateDiceStatistics(expression)
+		if err != nil {
+			t.Fatalf("%s: %v", expression, err)
+		}
+		for seed := range uint64(200) {
+			result, err := NewSeededRoller(seed).Roll(expression)
+			if err != nil {
+				t.Fatalf("%s: %v", expression, err)
+			}
+			if stats.Results[normalizeOutcome(result.Value)] == nil {
+				t.Errorf("%s rolled %v with seed %d, which isn't one of its outcomes", expression, result.Value, seed)
+			}
+		}
+	}
+}
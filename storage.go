@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// appID identifies the app to Fyne, which keeps its preferences under it
+const appID = "io.github.aspadedace.dicestatisticscalculator"
+
+// storageDir returns the app's storage root, where Fyne keeps the app's files on the
+// desktop. It is worked out without Fyne so that files saved by the window can be
+// found without starting it.
+func storageDir() (string, error) {
+	var configDir string
+	switch runtime.GOOS {
+	case "windows":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(home, "AppData", "Roaming")
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(home, "Library", "Preferences")
+	default:
+		var err error
+		configDir, err = os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(configDir, "fyne", appID), nil
+}
+
+// storageLockFile is the file in the storage root that is locked while the history and
+// the ledger are read and written again
+const storageLockFile = "storage.lock"
+
+// lockStorage waits until no other window or REPL session is changing the files in the
+// storage root, so none of them overwrites another's rolls, and returns a function that
+// lets them again
+func lockStorage() (func(), error) {
+	dir, err := storageDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, storageLockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking storage: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// loadJSON reads a file in the storage root into v, leaving v alone if it hasn't been saved yet
+func loadJSON(name string, v any) error {
+	dir, err := storageDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON writes v to a file in the storage root. It writes a temporary file first
+// so a crash part way through can't leave the old file half overwritten.
+func saveJSON(name string, v any) error {
+	dir, err := storageDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package main
+
+import "errors"
+
+// isTerminal reports false, as the line editor can't put this system's terminals into
+// raw mode, so the REPL reads plain lines instead
+func isTerminal(fd int) bool {
+	return false
+}
+
+// makeRaw isn't supported on this system
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode isn't supported on this system")
+}